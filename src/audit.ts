@@ -0,0 +1,69 @@
+import { appendFileSync, mkdirSync, existsSync, statSync, readFileSync, writeFileSync, unlinkSync, readdirSync } from "node:fs";
+import { hostname } from "node:os";
+import { dirname, basename, join } from "node:path";
+import type { Metrics } from "./metrics";
+
+export interface AuditRecord {
+  hostname: string;
+  timestamp: string;
+  components: string[];
+  changed: number;
+  failed: number;
+  version: string;
+}
+
+export function auditRecord(components: string[], metrics: Metrics, failures: string[], version: string, date: Date): AuditRecord {
+  return {
+    hostname: hostname(),
+    timestamp: date.toISOString(),
+    components,
+    changed: metrics.installed + metrics.linked,
+    failed: failures.length,
+    version,
+  };
+}
+
+export interface Rotation {
+  maxBytes: number;
+  keep: number;
+}
+
+const SIZE_UNITS: Record<string, number> = { "": 1, b: 1, k: 1024, kb: 1024, m: 1024 ** 2, mb: 1024 ** 2, g: 1024 ** 3, gb: 1024 ** 3 };
+
+export function parseSize(value: string): number | null {
+  const match = value.trim().match(/^(\d+)\s*([a-z]*)$/i);
+  if (!match) return null;
+  const unit = SIZE_UNITS[match[2].toLowerCase()];
+  return unit === undefined ? null : parseInt(match[1], 10) * unit;
+}
+
+function rotatedName(path: string, date: Date): string {
+  return `${path}.${date.toISOString().replace(/[-:.]/g, "")}.gz`;
+}
+
+// Once the file reaches maxBytes it is gzipped to <file>.<timestamp>.gz and
+// a fresh one is started. Only the newest `keep` rotated files are kept.
+export function rotateIfNeeded(path: string, rotation: Rotation, now: Date = new Date()): string | null {
+  if (!existsSync(path) || statSync(path).size < rotation.maxBytes) return null;
+  const rotated = rotatedName(path, now);
+  writeFileSync(rotated, Bun.gzipSync(readFileSync(path)));
+  unlinkSync(path);
+
+  const prefix = basename(path) + ".";
+  const old = readdirSync(dirname(path))
+    .filter((f) => f.startsWith(prefix) && /^\d{8}T\d{9}Z\.gz$/.test(f.slice(prefix.length)))
+    .sort();
+  for (const f of old.slice(0, Math.max(0, old.length - rotation.keep))) {
+    unlinkSync(join(dirname(path), f));
+  }
+  return rotated;
+}
+
+// One line per run, written with a single append. That keeps concurrent runs
+// on one local disk from interleaving; over NFS appends aren't atomic, so give
+// each machine its own file there.
+export function appendAudit(path: string, record: AuditRecord, rotation?: Rotation): void {
+  mkdirSync(dirname(path), { recursive: true });
+  if (rotation) rotateIfNeeded(path, rotation);
+  appendFileSync(path, JSON.stringify(record) + "\n");
+}
@@ -0,0 +1,77 @@
+import { color } from "./ui";
+import { installComponent, RunOptions } from "./installer";
+import type { ResolvedComponent } from "./config";
+
+export interface Batch {
+  prefix: string;
+  packages: string[];
+  components: string[];
+}
+
+const SAFE_TOKEN = /^[\w@%+=:,./-]+$/;
+
+// Splits "brew install --cask foo bar" into the part shared by a batch
+// ("brew install --cask") and its packages. Anything that is not a plain
+// `<...> install [flags] <packages>` command is left alone.
+export function splitInstallCommand(command: string): { prefix: string; packages: string[] } | null {
+  const tokens = command.trim().split(/\s+/);
+  if (!tokens.every((t) => SAFE_TOKEN.test(t))) return null;
+  let i = tokens.indexOf("install");
+  if (i < 1) return null;
+  i++;
+  while (i < tokens.length && tokens[i].startsWith("-")) i++;
+  const packages = tokens.slice(i);
+  if (packages.length === 0 || packages.some((p) => p.startsWith("-"))) return null;
+  return { prefix: tokens.slice(0, i).join(" "), packages };
+}
+
+function batchable(comp: ResolvedComponent): boolean {
+  return typeof comp.installCommand === "string" &&
+    !comp.isInstalled &&
+    !comp.skipReason &&
+    !comp.shell &&
+    !comp.successIf &&
+    !comp.failIf;
+}
+
+export function planBatches(components: ResolvedComponent[]): Batch[] {
+  const batches = new Map<string, Batch>();
+  for (const comp of components) {
+    if (!batchable(comp)) continue;
+    const split = splitInstallCommand(comp.installCommand as string);
+    if (!split) continue;
+    const batch = batches.get(split.prefix) ?? { prefix: split.prefix, packages: [], components: [] };
+    for (const p of split.packages) {
+      if (!batch.packages.includes(p)) batch.packages.push(p);
+    }
+    batch.components.push(comp.name);
+    batches.set(split.prefix, batch);
+  }
+  return [...batches.values()].filter((b) => b.components.length > 1);
+}
+
+export function batchCommand(batch: Batch): string {
+  return `${batch.prefix} ${batch.packages.join(" ")}`;
+}
+
+// Runs each batch once. Components in a batch that succeeded are returned
+// with the command that installed them; a failed batch returns nothing so
+// its components fall back to installing one by one and get their own result.
+export async function runBatches(batches: Batch[], options: RunOptions): Promise<Map<string, string>> {
+  const installed = new Map<string, string>();
+  for (const batch of batches) {
+    const command = batchCommand(batch);
+    if (options.report) {
+      process.stdout.write(`\n  ${color(`batch: ${batch.components.join(", ")}`, "bold")}\n`);
+    }
+    const result = await installComponent(batch.prefix, command, options);
+    if (result.failed) {
+      process.stdout.write(
+        `  ${color("[warn]", "yellow")} batch install failed, installing ${batch.components.join(", ")} one by one\n`
+      );
+      continue;
+    }
+    for (const name of batch.components) installed.set(name, command);
+  }
+  return installed;
+}
@@ -1,23 +1,68 @@
+import { parseAge } from "./gc";
+import { parseSize } from "./audit";
+
 export interface ParsedArgs {
   mode: "interactive" | "direct" | "meta";
-  meta: "help" | "version" | "upgrade" | null;
+  meta: "help" | "version" | "upgrade" | "schema" | null;
   install: string[];
   uninstall: string[];
+  purge: string[];
   link: string[];
   postinstall: string[];
   postlink: string[];
   exportDefaults: boolean;
   importDefaults: boolean;
   list: boolean;
+  json: boolean;
+  doctor: boolean;
+  gc: boolean;
+  gcOlderThan: string;
   dryRun: boolean;
   verbose: boolean;
   interactiveAction: string | null;
+  repo: string | null;
+  repoRef: string | null;
+  targetPrefix: string | null;
+  changedExit: boolean;
+  reportFile: string | null;
+  failFast: boolean;
+  simulateOS: string | null;
+  rollbackOnFailure: boolean;
+  onlyRestricted: boolean;
+  compact: boolean;
+  listLinks: boolean;
+  linkFilter: string[];
+  broken: boolean;
+  maxRetries: number | null;
+  batchInstalls: boolean;
+  edit: boolean;
+  forceUninstall: boolean;
+  noReplace: boolean;
+  expandDryRun: boolean;
+  yes: boolean;
+  snapshotFile: string | null;
+  applyFile: string | null;
+  auditFile: string | null;
+  adopt: boolean;
+  disabledManagers: string[];
+  logMaxSize: number | null;
+  logKeep: number;
+  gitDiffBase: string | null;
+  requireGit: boolean;
+  allowDirty: boolean;
 }
 
 const VALID_FLAGS = new Set([
-  "install", "uninstall", "link", "postinstall", "postlink",
-  "defaults-export", "defaults-import", "list", "upgrade",
-  "dry-run", "verbose", "help", "version",
+  "install", "uninstall", "purge", "link", "postinstall", "postlink",
+  "defaults-export", "defaults-import", "list", "doctor", "gc", "upgrade",
+  "dry-run", "verbose", "help", "version", "repo", "repo-ref", "target-prefix",
+  "changed-exit", "report", "fail-fast", "simulate-os",
+  "rollback-on-failure", "gc-older-than", "json", "only-restricted",
+  "compact", "list-links", "broken", "max-retries",
+  "batch-installs", "edit", "force-uninstall", "schema",
+  "no-replace", "expand-dry-run", "yes", "snapshot", "apply",
+  "audit-file", "adopt", "disable-manager", "log-max-size", "log-keep",
+  "components-from-git-diff", "require-git", "allow-dirty",
 ]);
 
 const SHORT_FLAGS: Record<string, string> = {
@@ -28,31 +73,136 @@ const SHORT_FLAGS: Record<string, string> = {
   "I": "defaults-import",
   "v": "verbose",
   "h": "help",
+  "y": "yes",
 };
 
 const VALUE_FLAGS = new Set([
-  "install", "uninstall", "link", "postinstall", "postlink",
+  "install", "uninstall", "purge", "link", "postinstall", "postlink",
+]);
+
+const REQUIRED_VALUE_FLAGS = new Set([
+  "install", "purge",
 ]);
 
+const OPTION_FLAGS = new Set([
+  "repo", "repo-ref", "target-prefix", "report", "simulate-os", "gc-older-than", "max-retries",
+  "snapshot", "apply", "audit-file", "disable-manager", "log-max-size", "log-keep",
+  "components-from-git-diff",
+]);
+
+const SIMULATED_OSES = new Set(["mac", "linux", "windows"]);
+
 const BOOL_ACTION_FLAGS = new Set([
-  "defaults-export", "defaults-import", "list", "upgrade",
+  "defaults-export", "defaults-import", "list", "list-links", "doctor", "gc", "edit", "upgrade",
 ]);
 
+const SUBCOMMANDS: Record<string, string> = {
+  install: "install",
+  uninstall: "uninstall",
+  purge: "purge",
+  link: "link",
+  postinstall: "postinstall",
+  postlink: "postlink",
+  list: "list",
+  "list-links": "list-links",
+  doctor: "doctor",
+  gc: "gc",
+  edit: "edit",
+  upgrade: "upgrade",
+  help: "help",
+  version: "version",
+  schema: "schema",
+};
+
+const DEFAULTS_SUBCOMMANDS: Record<string, string> = {
+  export: "defaults-export",
+  import: "defaults-import",
+};
+
+export function expandSubcommand(argv: string[]): string[] {
+  const verb = argv[1];
+  if (verb === undefined || verb.startsWith("-")) return argv;
+
+  if (verb === "defaults") {
+    const sub = argv[2];
+    const flag = sub !== undefined ? DEFAULTS_SUBCOMMANDS[sub] : undefined;
+    if (!flag) {
+      throw new Error("Command defaults requires export or import");
+    }
+    return [argv[0], `--${flag}`, ...argv.slice(3)];
+  }
+
+  const flag = SUBCOMMANDS[verb];
+  if (!flag) return argv;
+
+  let i = 2;
+  const names: string[] = [];
+  while (i < argv.length && !argv[i].startsWith("-")) {
+    names.push(argv[i]);
+    i++;
+  }
+
+  const expanded = [argv[0]];
+  if (VALUE_FLAGS.has(flag) && names.length > 0) {
+    for (const name of names) expanded.push(`--${flag}`, name);
+  } else {
+    expanded.push(`--${flag}`, ...names);
+  }
+  return [...expanded, ...argv.slice(i)];
+}
+
 export function parseArgs(argv: string[]): ParsedArgs {
+  argv = expandSubcommand(argv);
+
   const result: ParsedArgs = {
     mode: "interactive",
     meta: null,
     install: [],
     uninstall: [],
+    purge: [],
     link: [],
     postinstall: [],
     postlink: [],
     exportDefaults: false,
     importDefaults: false,
     list: false,
+    json: false,
+    doctor: false,
+    gc: false,
+    gcOlderThan: "30d",
     dryRun: false,
     verbose: false,
     interactiveAction: null,
+    repo: null,
+    repoRef: null,
+    targetPrefix: null,
+    changedExit: false,
+    reportFile: null,
+    failFast: false,
+    simulateOS: null,
+    rollbackOnFailure: false,
+    onlyRestricted: false,
+    compact: false,
+    listLinks: false,
+    linkFilter: [],
+    broken: false,
+    maxRetries: null,
+    batchInstalls: false,
+    edit: false,
+    forceUninstall: false,
+    noReplace: false,
+    expandDryRun: false,
+    yes: false,
+    snapshotFile: null,
+    applyFile: null,
+    auditFile: null,
+    adopt: false,
+    disabledManagers: [],
+    logMaxSize: null,
+    logKeep: 5,
+    gitDiffBase: null,
+    requireGit: false,
+    allowDirty: false,
   };
 
   let hasAction = false;
@@ -77,23 +227,79 @@ export function parseArgs(argv: string[]): ParsedArgs {
       if (name === "upgrade") {
         return { ...result, mode: "meta", meta: "upgrade" };
       }
+      if (name === "schema") {
+        return { ...result, mode: "meta", meta: "schema" };
+      }
 
       if (VALUE_FLAGS.has(name)) {
         i++;
         if (i >= argv.length || argv[i].startsWith("-")) {
-          if (name === "install") {
-            throw new Error("Flag --install requires a component name");
+          if (REQUIRED_VALUE_FLAGS.has(name)) {
+            throw new Error(`Flag --${name} requires a component name`);
           }
           result.interactiveAction = name;
           hasAction = true;
           i--;
         } else {
-          const key = name as keyof Pick<ParsedArgs, "install" | "uninstall" | "link" | "postinstall" | "postlink">;
+          const key = name as keyof Pick<ParsedArgs, "install" | "uninstall" | "purge" | "link" | "postinstall" | "postlink">;
           result[key].push(argv[i]);
           hasAction = true;
         }
+      } else if (OPTION_FLAGS.has(name)) {
+        i++;
+        if (i >= argv.length || argv[i].startsWith("-")) {
+          throw new Error(`Flag --${name} requires a value`);
+        }
+        if (name === "repo") result.repo = argv[i];
+        if (name === "repo-ref") result.repoRef = argv[i];
+        if (name === "target-prefix") result.targetPrefix = argv[i];
+        if (name === "report") result.reportFile = argv[i];
+        if (name === "audit-file") result.auditFile = argv[i];
+        if (name === "disable-manager") result.disabledManagers.push(argv[i]);
+        if (name === "log-max-size") {
+          const size = parseSize(argv[i]);
+          if (!size) {
+            throw new Error(`Flag --log-max-size expects a size like 10MB, 512K, or 1048576`);
+          }
+          result.logMaxSize = size;
+        }
+        if (name === "log-keep") {
+          if (!/^\d+$/.test(argv[i])) {
+            throw new Error(`Flag --log-keep expects a non-negative integer`);
+          }
+          result.logKeep = parseInt(argv[i], 10);
+        }
+        if (name === "snapshot" || name === "apply" || name === "components-from-git-diff") {
+          if (name === "snapshot") result.snapshotFile = argv[i];
+          if (name === "apply") result.applyFile = argv[i];
+          if (name === "components-from-git-diff") result.gitDiffBase = argv[i];
+          hasAction = true;
+        }
+        if (name === "gc-older-than") {
+          if (parseAge(argv[i]) === null) {
+            throw new Error(`Flag --gc-older-than expects an age like 30d, 12h, or 45m`);
+          }
+          result.gcOlderThan = argv[i];
+        }
+        if (name === "max-retries") {
+          if (!/^\d+$/.test(argv[i])) {
+            throw new Error(`Flag --max-retries expects a non-negative integer`);
+          }
+          result.maxRetries = parseInt(argv[i], 10);
+        }
+        if (name === "simulate-os") {
+          if (!SIMULATED_OSES.has(argv[i])) {
+            throw new Error(`Flag --simulate-os must be one of: mac, linux, windows`);
+          }
+          result.simulateOS = argv[i];
+          result.dryRun = true;
+        }
       } else if (BOOL_ACTION_FLAGS.has(name)) {
         if (name === "list") result.list = true;
+        if (name === "list-links") result.listLinks = true;
+        if (name === "doctor") result.doctor = true;
+        if (name === "gc") result.gc = true;
+        if (name === "edit") result.edit = true;
         if (name === "defaults-export") result.exportDefaults = true;
         if (name === "defaults-import") result.importDefaults = true;
         hasAction = true;
@@ -101,6 +307,37 @@ export function parseArgs(argv: string[]): ParsedArgs {
         result.dryRun = true;
       } else if (name === "verbose") {
         result.verbose = true;
+      } else if (name === "changed-exit") {
+        result.changedExit = true;
+      } else if (name === "fail-fast") {
+        result.failFast = true;
+      } else if (name === "rollback-on-failure") {
+        result.rollbackOnFailure = true;
+      } else if (name === "json") {
+        result.json = true;
+      } else if (name === "only-restricted") {
+        result.onlyRestricted = true;
+      } else if (name === "compact") {
+        result.compact = true;
+      } else if (name === "broken") {
+        result.broken = true;
+      } else if (name === "batch-installs") {
+        result.batchInstalls = true;
+      } else if (name === "force-uninstall") {
+        result.forceUninstall = true;
+      } else if (name === "no-replace") {
+        result.noReplace = true;
+      } else if (name === "yes") {
+        result.yes = true;
+      } else if (name === "adopt") {
+        result.adopt = true;
+      } else if (name === "require-git") {
+        result.requireGit = true;
+      } else if (name === "allow-dirty") {
+        result.allowDirty = true;
+      } else if (name === "expand-dry-run") {
+        result.expandDryRun = true;
+        result.dryRun = true;
       }
     } else if (arg.startsWith("-") && arg.length > 1) {
       const flags = arg.slice(1);
@@ -129,7 +366,7 @@ export function parseArgs(argv: string[]): ParsedArgs {
             hasAction = true;
             i--;
           } else {
-            const key = resolved as keyof Pick<ParsedArgs, "install" | "uninstall" | "link" | "postinstall" | "postlink">;
+            const key = resolved as keyof Pick<ParsedArgs, "install" | "uninstall" | "purge" | "link" | "postinstall" | "postlink">;
             result[key].push(argv[i]);
             hasAction = true;
           }
@@ -139,8 +376,12 @@ export function parseArgs(argv: string[]): ParsedArgs {
           hasAction = true;
         } else if (resolved === "verbose") {
           result.verbose = true;
+        } else if (resolved === "yes") {
+          result.yes = true;
         }
       }
+    } else if (result.listLinks) {
+      result.linkFilter.push(arg);
     }
 
     i++;
@@ -150,13 +391,36 @@ export function parseArgs(argv: string[]): ParsedArgs {
     result.mode = "interactive";
   } else if (result.interactiveAction && 
     result.install.length === 0 && result.uninstall.length === 0 &&
-    result.link.length === 0 && result.postinstall.length === 0 &&
+    result.purge.length === 0 && result.link.length === 0 && result.postinstall.length === 0 &&
     result.postlink.length === 0 && !result.exportDefaults &&
-    !result.importDefaults && !result.list) {
+    !result.importDefaults && !result.list && !result.listLinks && !result.doctor && !result.gc && !result.edit &&
+    !result.snapshotFile && !result.applyFile && !result.gitDiffBase) {
     result.mode = "interactive";
   } else {
     result.mode = "direct";
   }
 
+  if (result.json && !result.list && !result.listLinks) {
+    throw new Error("Flag --json only applies to --list and --list-links");
+  }
+
+  if (result.mode === "interactive" && result.auditFile) {
+    throw new Error("Flag --audit-file only records non-interactive runs; name the components with -i, -l, or --apply");
+  }
+  if (result.mode === "interactive" && result.reportFile) {
+    throw new Error("Flag --report only reports non-interactive runs; name the components with -i, -l, or --apply");
+  }
+  if (result.mode === "interactive") {
+    const directOnly: [boolean, string][] = [
+      [result.failFast, "--fail-fast"],
+      [result.batchInstalls, "--batch-installs"],
+      [result.compact, "--compact"],
+      [result.rollbackOnFailure, "--rollback-on-failure"],
+    ];
+    for (const [set, flag] of directOnly) {
+      if (set) throw new Error(`Flag ${flag} only applies to non-interactive runs; name the components with -i, -l, or --apply`);
+    }
+  }
+
   return result;
 }
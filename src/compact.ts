@@ -0,0 +1,77 @@
+import { color } from "./ui";
+import { clearLine } from "./renderer";
+
+export type CompactStatus = "ok" | "failed" | "skipped";
+
+export interface Writer {
+  write(chunk: string): unknown;
+  isTTY?: boolean;
+}
+
+const MARKS: Record<CompactStatus, string> = {
+  ok: color("✓", "green"),
+  failed: color("✗", "red"),
+  skipped: color("-", "dim"),
+};
+
+const RECENT = 3;
+
+export class CompactProgress {
+  private done = 0;
+  private recent: string[] = [];
+  private open = false;
+
+  constructor(private total: number, private out: Writer) {}
+
+  private counter(): string {
+    return `[${this.done}/${this.total}]`;
+  }
+
+  start(name: string): void {
+    if (!this.out.isTTY) return;
+    const parts = [...this.recent, `${name} …`];
+    this.out.write(`${clearLine()}  ${this.counter()} ${parts.join("  ")}`);
+    this.open = true;
+  }
+
+  finish(name: string, status: CompactStatus): void {
+    this.done++;
+    const entry = `${name} ${MARKS[status]}`;
+    if (!this.out.isTTY) {
+      this.out.write(`  ${this.counter()} ${entry}\n`);
+      return;
+    }
+    this.recent = [...this.recent, entry].slice(-RECENT);
+    this.out.write(`${clearLine()}  ${this.counter()} ${this.recent.join("  ")}`);
+    this.open = true;
+  }
+
+  // Ends the status line so output that isn't ours starts on a line of its
+  // own. The next start or finish draws a fresh status line below it.
+  interrupt(): void {
+    if (!this.open) return;
+    this.out.write("\n");
+    this.open = false;
+  }
+
+  end(): void {
+    this.interrupt();
+  }
+}
+
+// Errors and warnings would otherwise be written onto the end of the status
+// line. Until the returned function is called, a write to any of these
+// streams ends the line first. The progress must write through a stream that
+// is not in the list.
+export function interruptOnWrite(progress: CompactProgress, streams: Writer[]): () => void {
+  const originals = streams.map((s) => s.write);
+  streams.forEach((s, i) => {
+    s.write = ((...args: Parameters<Writer["write"]>) => {
+      progress.interrupt();
+      return originals[i].apply(s, args);
+    }) as Writer["write"];
+  });
+  return () => streams.forEach((s, i) => {
+    s.write = originals[i];
+  });
+}
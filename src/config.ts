@@ -1,31 +1,189 @@
-import { expandPath } from "./utils";
-import { join } from "node:path";
+import { expandPath, targetPath, displayTarget, sourcePath, samePath } from "./utils";
+import { Hook } from "./hooks";
+import { DefaultsMode, DefaultsFormat, DefaultsValue, splitDefaultsKey } from "./defaults";
 import { existsSync, readlinkSync, lstatSync } from "node:fs";
+import { color } from "./ui";
+
+export type Command = string | string[];
 
 export interface Component {
   name: string;
-  install: Record<string, string>;
+  install: Record<string, Command>;
   uninstall: Record<string, string>;
   link: Record<string, string[]>;
-  postinstall?: string;
-  postlink?: string;
+  osLinks?: Record<string, Record<string, string[]>>;
+  postinstall?: Hook;
+  postlink?: Hook;
+  preuninstall?: Hook;
   defaults: Record<string, string>;
+  defaultsCurrentHost?: Record<string, string>;
+  defaultsMode?: DefaultsMode;
+  defaultsFormat?: DefaultsFormat;
+  defaultsSet: Record<string, DefaultsValue>;
   os?: string[];
   check?: string;
+  description?: string;
+  shell?: string;
+  ifFileExists?: string;
+  unlessFileExists?: string;
+  allowMissingSource?: boolean;
+  rollback?: boolean;
+  successIf?: string;
+  failIf?: string;
+  elevated?: boolean;
+  retries?: number;
+  retryBackoff?: "fixed" | "exponential";
+  retryDelay?: number;
+  runFirst?: boolean;
+  runLast?: boolean;
 }
 
 export interface ResolvedComponent extends Component {
   availableManager: string | null;
-  installCommand: string | null;
+  installCommand: Command | null;
+  uninstallCommand: string | null;
   hasDefaults: boolean;
   hasLinks: boolean;
   hasInstall: boolean;
   allLinksDone: boolean;
   isInstalled: boolean;
+  skipReason: string | null;
 }
 
 export interface Config {
   components: Component[];
+  postrun?: Hook;
+  postrunAlways?: boolean;
+  disabledManagers?: string[];
+}
+
+const LINK_OS_KEYS: Record<string, string> = {
+  mac: "mac",
+  darwin: "mac",
+  linux: "linux",
+  windows: "windows",
+};
+
+function parseDefaultsFormat(value: unknown, where: string): DefaultsFormat {
+  if (value !== "auto" && value !== "xml") {
+    throw new Error(`${where}defaults_format must be "auto" or "xml"`);
+  }
+  return value;
+}
+
+export const COMPONENT_KEYS = new Set([
+  "install", "uninstall", "link", "postinstall", "postlink", "preuninstall", "dotfiles", "defaults", "defaults_currenthost", "defaults_set",
+  "defaults_mode", "defaults_format", "os", "check", "description", "shell",
+  "if_file_exists", "unless_file_exists", "allow_missing_source", "rollback",
+  "success_if", "fail_if", "elevated", "retries", "retry_backoff", "retry_delay",
+  "run_first", "run_last",
+]);
+
+function editDistance(a: string, b: string): number {
+  const row = Array.from({ length: b.length + 1 }, (_, i) => i);
+  for (let i = 1; i <= a.length; i++) {
+    let prev = row[0];
+    row[0] = i;
+    for (let j = 1; j <= b.length; j++) {
+      const current = row[j];
+      row[j] = Math.min(row[j] + 1, row[j - 1] + 1, prev + (a[i - 1] === b[j - 1] ? 0 : 1));
+      prev = current;
+    }
+  }
+  return row[b.length];
+}
+
+function keyLine(raw: string, section: string, key: string): number | null {
+  const escape = (v: string) => v.replace(/[.*+?^${}()|[\]\\]/g, "\\$&");
+  const header = new RegExp(`^\\s*\\[\\s*"?${escape(section)}"?\\s*\\]\\s*(#.*)?$`);
+  const anyHeader = /^\s*\[/;
+  const entry = new RegExp(`^\\s*"?${escape(key)}"?\\s*[.=]`);
+  let inSection = false;
+  const lines = raw.split("\n");
+  for (let i = 0; i < lines.length; i++) {
+    if (anyHeader.test(lines[i])) {
+      inSection = header.test(lines[i]);
+    } else if (inSection && entry.test(lines[i])) {
+      return i + 1;
+    }
+  }
+  return null;
+}
+
+function unknownKeyError(filePath: string, raw: string, section: string, key: string): Error {
+  const line = keyLine(raw, section, key);
+  const where = line ? `${filePath}:${line}: ` : "";
+  const suggestion = [...COMPONENT_KEYS].find((k) => editDistance(k, key) <= 2);
+  const hint = suggestion ? ` (did you mean "${suggestion}"?)` : "";
+  return new Error(`${where}[${section}] unknown key "${key}"${hint}`);
+}
+
+function parsePattern(value: unknown, name: string, key: string): string {
+  const pattern = String(value);
+  try {
+    new RegExp(pattern);
+  } catch (e: any) {
+    throw new Error(`[${name}] ${key} is not a valid regex: ${e.message}`);
+  }
+  return pattern;
+}
+
+const VERSION_PATTERN = /^v?\d+(\.\d+)*$/;
+
+export function compareVersions(a: string, b: string): number {
+  const pa = a.replace(/^v/, "").split(".").map(Number);
+  const pb = b.replace(/^v/, "").split(".").map(Number);
+  for (let i = 0; i < Math.max(pa.length, pb.length); i++) {
+    const diff = (pa[i] ?? 0) - (pb[i] ?? 0);
+    if (diff !== 0) return diff;
+  }
+  return 0;
+}
+
+// The release a build was made from: "1.2.0" for both "v1.2.0" and a
+// `git describe` string like "v1.2.0-3-gabc123". Null when there is none.
+export function releaseVersion(version: string): string | null {
+  const match = version.match(/^v?(\d+(?:\.\d+)*)(?:[-+].*)?$/);
+  return match ? match[1] : null;
+}
+
+// Development builds have no version to compare against, so they accept any
+// config. Any other version dot can't read (a bare commit hash) gets a warning.
+export function checkMinVersion(required: unknown, current: string = process.env.DOT_VERSION || "dev"): void {
+  const min = String(required);
+  if (!VERSION_PATTERN.test(min)) {
+    throw new Error(`min_version must look like "1.3.0", got "${min}"`);
+  }
+  if (current === "dev") return;
+  const release = releaseVersion(current);
+  if (!release) {
+    process.stderr.write(`${color("[warn]", "yellow")} can't tell which release dot ${current} is; skipping min_version ${min}\n`);
+    return;
+  }
+  if (compareVersions(release, min) < 0) {
+    throw new Error(`this config requires dot >= ${min.replace(/^v/, "")}, you have ${current.replace(/^v/, "")}`);
+  }
+}
+
+export function dotfileTarget(src: string): string | null {
+  const base = src.replace(/\/+$/, "").split("/").pop() || "";
+  if (!base || base === "." || base === "..") return null;
+  return `~/${base.startsWith(".") ? base : `.${base}`}`;
+}
+
+function expandDotfiles(value: unknown, name: string): [string, string][] {
+  if (!Array.isArray(value)) {
+    throw new Error(`[${name}] dotfiles must be a list of repo paths`);
+  }
+  return value.map((entry) => {
+    const target = dotfileTarget(String(entry));
+    if (!target) throw new Error(`[${name}] dotfiles entry "${entry}" has no file name to link`);
+    return [String(entry), target];
+  });
+}
+
+function linkTargets(value: unknown): string[] {
+  return Array.isArray(value) ? value.map(String) : [String(value)];
 }
 
 export async function parseConfig(path?: string): Promise<Config> {
@@ -43,8 +201,16 @@ export async function parseConfig(path?: string): Promise<Config> {
   }
 
   if (!parsed || typeof parsed !== "object") return { components: [] };
+  if (parsed.min_version !== undefined) checkMinVersion(parsed.min_version);
+  if (parsed.disabled_managers !== undefined &&
+    (!Array.isArray(parsed.disabled_managers) || !parsed.disabled_managers.every((m: unknown) => typeof m === "string"))) {
+    throw new Error(`disabled_managers must be a list of manager names`);
+  }
 
   const components: Component[] = [];
+  const defaultsFormat = parsed.defaults_format !== undefined
+    ? parseDefaultsFormat(parsed.defaults_format, "")
+    : undefined;
   for (const [name, section] of Object.entries(parsed)) {
     if (typeof section !== "object" || section === null || Array.isArray(section)) continue;
 
@@ -56,22 +222,85 @@ export async function parseConfig(path?: string): Promise<Config> {
       uninstall: {},
       link: {},
       defaults: {},
+      defaultsSet: {},
     };
 
+    if (s.run_first === true && s.run_last === true) {
+      throw new Error(`[${name}] run_first and run_last cannot both be set`);
+    }
     for (const [key, value] of Object.entries(s)) {
+      if (!COMPONENT_KEYS.has(key)) {
+        throw unknownKeyError(filePath, raw, name, key);
+      }
       if (key === "os") {
         if (Array.isArray(value)) {
           component.os = value.map(String);
         }
       } else if (key === "postinstall") {
-        component.postinstall = String(value);
+        component.postinstall = parseHook(value);
       } else if (key === "postlink") {
-        component.postlink = String(value);
+        component.postlink = parseHook(value);
+      } else if (key === "preuninstall") {
+        component.preuninstall = parseHook(value);
       } else if (key === "check") {
         component.check = String(value);
+      } else if (key === "description") {
+        component.description = String(value);
+      } else if (key === "shell") {
+        component.shell = String(value);
+      } else if (key === "success_if") {
+        component.successIf = parsePattern(value, name, key);
+      } else if (key === "fail_if") {
+        component.failIf = parsePattern(value, name, key);
+      } else if (key === "rollback") {
+        component.rollback = value === true;
+      } else if (key === "retries") {
+        if (typeof value !== "number" || !Number.isInteger(value) || value < 0) {
+          throw new Error(`[${name}] retries must be a non-negative integer`);
+        }
+        component.retries = value;
+      } else if (key === "retry_backoff") {
+        if (value !== "fixed" && value !== "exponential") {
+          throw new Error(`[${name}] retry_backoff must be "fixed" or "exponential"`);
+        }
+        component.retryBackoff = value;
+      } else if (key === "retry_delay") {
+        if (typeof value !== "number" || value < 0) {
+          throw new Error(`[${name}] retry_delay must be a non-negative number of seconds`);
+        }
+        component.retryDelay = value;
+      } else if (key === "dotfiles") {
+        for (const [src, target] of expandDotfiles(value, name)) {
+          if (s.link && typeof s.link === "object" && src in s.link) {
+            throw new Error(`[${name}] "${src}" is in both dotfiles and link`);
+          }
+          component.link[src] = [target];
+        }
+      } else if (key === "run_first") {
+        component.runFirst = value === true;
+      } else if (key === "run_last") {
+        component.runLast = value === true;
+      } else if (key === "elevated") {
+        component.elevated = value === true;
+      } else if (key === "allow_missing_source") {
+        component.allowMissingSource = value === true;
+      } else if (key === "if_file_exists") {
+        component.ifFileExists = String(value);
+      } else if (key === "unless_file_exists") {
+        component.unlessFileExists = String(value);
+      } else if (key === "defaults_format") {
+        component.defaultsFormat = parseDefaultsFormat(value, `[${name}] `);
+      } else if (key === "defaults_mode") {
+        if (value !== "replace" && value !== "merge") {
+          throw new Error(`[${name}] defaults_mode must be "replace" or "merge"`);
+        }
+        component.defaultsMode = value;
       } else if (key === "install" && typeof value === "object" && value !== null && !Array.isArray(value)) {
         for (const [mgr, cmd] of Object.entries(value as Record<string, unknown>)) {
-          component.install[mgr] = String(cmd);
+          if (Array.isArray(cmd) && cmd.length === 0) {
+            throw new Error(`[${name}] install.${mgr} is an empty list; give the command and its arguments`);
+          }
+          component.install[mgr] = Array.isArray(cmd) ? cmd.map(String) : String(cmd);
         }
       } else if (key === "uninstall" && typeof value === "object" && value !== null && !Array.isArray(value)) {
         for (const [mgr, cmd] of Object.entries(value as Record<string, unknown>)) {
@@ -79,45 +308,107 @@ export async function parseConfig(path?: string): Promise<Config> {
         }
       } else if (key === "link" && typeof value === "object" && value !== null && !Array.isArray(value)) {
         for (const [src, targets] of Object.entries(value as Record<string, unknown>)) {
-          if (Array.isArray(targets)) {
-            component.link[src] = targets.map(String);
+          if (typeof targets === "object" && targets !== null && !Array.isArray(targets)) {
+            const byOS: Record<string, string[]> = {};
+            for (const [osKey, osTargets] of Object.entries(targets as Record<string, unknown>)) {
+              const os = LINK_OS_KEYS[osKey];
+              if (!os) {
+                throw new Error(`[${name}] link "${src}" has unknown OS "${osKey}" (use mac, linux, or windows)`);
+              }
+              byOS[os] = linkTargets(osTargets);
+            }
+            component.osLinks = { ...component.osLinks, [src]: byOS };
           } else {
-            component.link[src] = [String(targets)];
+            component.link[src] = linkTargets(targets);
           }
         }
       } else if (key === "defaults" && typeof value === "object" && value !== null && !Array.isArray(value)) {
         for (const [domain, file] of Object.entries(value as Record<string, unknown>)) {
           component.defaults[domain] = String(file);
         }
+      } else if (key === "defaults_currenthost" && typeof value === "object" && value !== null && !Array.isArray(value)) {
+        component.defaultsCurrentHost = {};
+        for (const [domain, file] of Object.entries(value as Record<string, unknown>)) {
+          component.defaultsCurrentHost[domain] = String(file);
+        }
+      } else if (key === "defaults_set" && typeof value === "object" && value !== null && !Array.isArray(value)) {
+        for (const [entry, v] of Object.entries(value as Record<string, unknown>)) {
+          if (!splitDefaultsKey(entry)) {
+            throw new Error(`[${name}] defaults_set key "${entry}" must be "<domain> <key>"`);
+          }
+          component.defaultsSet[entry] = typeof v === "boolean" || typeof v === "number" ? v : String(v);
+        }
       }
     }
 
+    validateLinkTargets(component);
+    if (!component.defaultsFormat && defaultsFormat) component.defaultsFormat = defaultsFormat;
+
     if (Object.keys(component.install).length > 0 ||
         Object.keys(component.uninstall).length > 0 ||
         Object.keys(component.link).length > 0 ||
+        component.osLinks ||
         Object.keys(component.defaults).length > 0 ||
+        component.defaultsCurrentHost ||
+        Object.keys(component.defaultsSet).length > 0 ||
         component.postinstall ||
         component.postlink) {
       components.push(component);
     }
   }
 
-  return { components };
+  return {
+    components,
+    postrun: parsed.postrun !== undefined ? parseHook(parsed.postrun) : undefined,
+    postrunAlways: parsed.postrun_always === true,
+    disabledManagers: parsed.disabled_managers,
+  };
+}
+
+function parseHook(value: unknown): Hook | undefined {
+  if (Array.isArray(value)) {
+    return value.length > 0 ? value.map(String) : undefined;
+  }
+  return String(value);
+}
+
+function validateLinkTargets(component: Component): void {
+  const variants = component.osLinks
+    ? ["mac", "linux", "windows"].map((os) => linksForOS(component, os))
+    : [component.link];
+  for (const links of variants) {
+    const seen = new Map<string, string>();
+    for (const [src, targets] of Object.entries(links)) {
+      for (const target of targets) {
+        // A ~user this machine doesn't know only fails the link, at link time.
+        const dest = displayTarget(target);
+        const other = seen.get(dest);
+        if (other !== undefined) {
+          throw new Error(`[${component.name}] links "${other}" and "${src}" both target ${target}`);
+        }
+        seen.set(dest, src);
+      }
+    }
+  }
+}
+
+export function displayName(component: Component): string {
+  return component.description || component.name;
 }
 
-function linksAllCorrect(component: Component): boolean {
+function linksAllCorrect(component: Component, targetPrefix?: string): boolean {
   const links = component.link;
   if (Object.keys(links).length === 0) return false;
   const repoDir = process.cwd();
   for (const [src, targets] of Object.entries(links)) {
-    const absSrc = join(repoDir, src);
+    const absSrc = sourcePath(repoDir, src);
     if (!existsSync(absSrc)) return false;
     for (const target of targets) {
-      const dest = expandPath(target);
-      if (!existsSync(dest)) return false;
       try {
+        const dest = targetPath(target, targetPrefix);
+        if (!existsSync(dest)) return false;
         if (!lstatSync(dest).isSymbolicLink()) return false;
-        if (readlinkSync(dest) !== absSrc) return false;
+        if (!samePath(readlinkSync(dest), absSrc)) return false;
       } catch {
         return false;
       }
@@ -126,6 +417,16 @@ function linksAllCorrect(component: Component): boolean {
   return true;
 }
 
+export function fileGateReason(component: Component): string | null {
+  if (component.ifFileExists && !existsSync(expandPath(component.ifFileExists))) {
+    return `${component.ifFileExists} does not exist`;
+  }
+  if (component.unlessFileExists && existsSync(expandPath(component.unlessFileExists))) {
+    return `${component.unlessFileExists} exists`;
+  }
+  return null;
+}
+
 export function isCheckInstalled(check: string): boolean {
   if (check.includes(" ")) {
     const result = Bun.spawnSync(["sh", "-c", check], { stdout: null, stderr: null });
@@ -134,40 +435,61 @@ export function isCheckInstalled(check: string): boolean {
   return Bun.which(check) !== null;
 }
 
-export function resolveComponents(config: Config, os: string): ResolvedComponent[] {
-  return config.components
+export function linksForOS(component: Component, os: string): Record<string, string[]> {
+  const links = { ...component.link };
+  for (const [src, byOS] of Object.entries(component.osLinks || {})) {
+    if (byOS[os]) links[src] = byOS[os];
+  }
+  return links;
+}
+
+export function firstAvailableCommand<T>(
+  commands: Record<string, T>,
+  disabled: Set<string> = new Set()
+): { manager: string; command: T } | null {
+  for (const [manager, command] of Object.entries(commands)) {
+    if (manager !== "any" && !disabled.has(manager) && Bun.which(manager)) return { manager, command };
+  }
+  return "any" in commands && !disabled.has("any") ? { manager: "any", command: commands["any"] } : null;
+}
+
+export function runRank(component: Component): number {
+  return component.runFirst ? 0 : component.runLast ? 2 : 1;
+}
+
+// Managers listed in the config's disabled_managers or passed with
+// --disable-manager are treated as if they were not on PATH. Links are checked
+// under targetPrefix when --target-prefix is given.
+export function resolveComponents(
+  config: Config,
+  os: string,
+  disabledManagers: string[] = [],
+  targetPrefix?: string
+): ResolvedComponent[] {
+  const disabled = new Set([...(config.disabledManagers ?? []), ...disabledManagers]);
+  return [...config.components]
+    .sort((a, b) => runRank(a) - runRank(b))
     .filter((c) => {
       if (!c.os || c.os.length === 0) return true;
       return c.os.includes(os);
     })
-    .map((c) => {
-      let availableManager: string | null = null;
-      let installCommand: string | null = null;
-
-      const managers = Object.keys(c.install);
-      for (const mgr of managers) {
-        if (mgr === "any") continue;
-        if (Bun.which(mgr)) {
-          availableManager = mgr;
-          installCommand = c.install[mgr];
-          break;
-        }
-      }
-
-      if (!availableManager && c.install["any"]) {
-        availableManager = "any";
-        installCommand = c.install["any"];
-      }
+    .map((component) => {
+      const c = { ...component, link: linksForOS(component, os) };
+      const install = firstAvailableCommand(c.install, disabled);
 
       return {
         ...c,
-        availableManager,
-        installCommand,
-        hasDefaults: Object.keys(c.defaults).length > 0,
+        availableManager: install?.manager ?? null,
+        installCommand: install?.command ?? null,
+        uninstallCommand: firstAvailableCommand(c.uninstall, disabled)?.command ?? null,
+        hasDefaults: Object.keys(c.defaults).length > 0 ||
+          Object.keys(c.defaultsCurrentHost ?? {}).length > 0 ||
+          Object.keys(c.defaultsSet).length > 0,
         hasLinks: Object.keys(c.link).length > 0,
         hasInstall: Object.keys(c.install).length > 0,
-        allLinksDone: linksAllCorrect(c),
+        allLinksDone: linksAllCorrect(c, targetPrefix),
         isInstalled: c.check ? isCheckInstalled(c.check) : false,
+        skipReason: fileGateReason(c),
       };
     });
 }
@@ -1,11 +1,18 @@
 import { color } from "./ui";
 import { join } from "node:path";
+import { existsSync, readFileSync } from "node:fs";
+
+export type DefaultsMode = "replace" | "merge";
+export type DefaultsFormat = "auto" | "xml";
 
 export interface RunOptions {
   dryRun: boolean;
   verbose: boolean;
   interactive: boolean;
   report?: boolean;
+  defaultsMode?: DefaultsMode;
+  defaultsFormat?: DefaultsFormat;
+  currentHost?: boolean;
 }
 
 export interface DefaultsResult {
@@ -15,9 +22,181 @@ export interface DefaultsResult {
   failed: boolean;
   dryRun: boolean;
   skipped: boolean;
+  changed?: boolean;
   reason?: string;
 }
 
+// -currentHost must come before the verb: `defaults -currentHost read <domain>`.
+export function defaultsCommand(args: string[], currentHost?: boolean): string[] {
+  return currentHost ? ["defaults", "-currentHost", ...args] : ["defaults", ...args];
+}
+
+export function exportCommand(domain: string, file: string, format?: DefaultsFormat, currentHost?: boolean): string[] {
+  return format === "xml" || file.endsWith(".xml")
+    ? defaultsCommand(["export", domain, "-"], currentHost)
+    : defaultsCommand(["read", domain], currentHost);
+}
+
+export function defaultsChanged(
+  domain: string,
+  file: string,
+  absFile: string,
+  format?: DefaultsFormat,
+  currentHost?: boolean
+): boolean {
+  const proc = Bun.spawnSync(exportCommand(domain, file, format, currentHost), { stdout: "pipe" });
+  if (!existsSync(absFile)) return true;
+  return !Buffer.from(proc.stdout).equals(readFileSync(absFile));
+}
+
+function escapeXml(value: string): string {
+  return value.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;");
+}
+
+function plistXml(value: unknown): string {
+  if (typeof value === "boolean") return value ? "<true/>" : "<false/>";
+  if (typeof value === "number") {
+    return Number.isInteger(value) ? `<integer>${value}</integer>` : `<real>${value}</real>`;
+  }
+  if (Array.isArray(value)) return `<array>${value.map(plistXml).join("")}</array>`;
+  if (typeof value === "object" && value !== null) {
+    const entries = Object.entries(value).map(([k, v]) => `<key>${escapeXml(k)}</key>${plistXml(v)}`);
+    return `<dict>${entries.join("")}</dict>`;
+  }
+  return `<string>${escapeXml(String(value))}</string>`;
+}
+
+export function defaultsWriteArgs(value: unknown): string[] {
+  if (typeof value === "boolean") return ["-bool", value ? "true" : "false"];
+  if (typeof value === "number") return [Number.isInteger(value) ? "-int" : "-float", String(value)];
+  if (typeof value === "string") return ["-string", value];
+  return [plistXml(value)];
+}
+
+export type DefaultsValue = string | number | boolean;
+
+function readValue(value: DefaultsValue): string {
+  if (typeof value === "boolean") return value ? "1" : "0";
+  return String(value);
+}
+
+export function splitDefaultsKey(entry: string): [string, string] | null {
+  const space = entry.indexOf(" ");
+  if (space <= 0 || space === entry.length - 1) return null;
+  return [entry.slice(0, space), entry.slice(space + 1)];
+}
+
+export async function setDefaults(
+  values: Record<string, DefaultsValue>,
+  options: RunOptions
+): Promise<DefaultsResult[]> {
+  const results: DefaultsResult[] = [];
+
+  if (Object.keys(values).length === 0) return results;
+
+  for (const [entry, value] of Object.entries(values)) {
+    const [domain, key] = splitDefaultsKey(entry) || [entry, ""];
+    const base: DefaultsResult = { domain, file: key, success: false, failed: false, dryRun: false, skipped: false };
+
+    if (process.platform !== "darwin") {
+      results.push({ ...base, skipped: true, reason: "defaults only available on macOS" });
+      continue;
+    }
+
+    const current = Bun.spawnSync(["defaults", "read", domain, key], { stdout: "pipe", stderr: "ignore" });
+    if (current.exitCode === 0 && current.stdout.toString().trim() === readValue(value)) {
+      if (options.verbose) {
+        process.stdout.write(`  ${color("[skip]", "dim")} ${domain} ${key}: already ${readValue(value)}\n`);
+      }
+      results.push({ ...base, success: true, skipped: true, changed: false });
+      continue;
+    }
+
+    if (options.dryRun) {
+      if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} would set ${domain} ${key} = ${value}\n`);
+      results.push({ ...base, success: true, dryRun: true, changed: true });
+      continue;
+    }
+
+    const proc = Bun.spawnSync(["defaults", "write", domain, key, ...defaultsWriteArgs(value)]);
+    if (proc.exitCode !== 0) {
+      if (options.verbose) {
+        process.stdout.write(`  ${color("[error]", "red")} ${domain} ${key}: defaults write failed (exit ${proc.exitCode})\n`);
+      }
+      results.push({ ...base, failed: true, reason: `defaults write exited with code ${proc.exitCode}` });
+      continue;
+    }
+    if (options.report) process.stdout.write(`  ${color("✓", "green")} set ${domain} ${key}\n`);
+    results.push({ ...base, success: true, changed: true });
+  }
+
+  return results;
+}
+
+export function lintPlist(absFile: string): string | null {
+  const proc = Bun.spawnSync(["plutil", "-lint", absFile], { stdout: "pipe", stderr: "pipe" });
+  if (proc.exitCode === 0) return null;
+  const output = (proc.stdout.toString() + proc.stderr.toString()).trim();
+  return `plist file is invalid: ${output || `plutil -lint exited with code ${proc.exitCode}`}`;
+}
+
+function unescapeXml(value: string): string {
+  return value
+    .replace(/&#x([0-9a-f]+);/gi, (_, hex) => String.fromCodePoint(parseInt(hex, 16)))
+    .replace(/&#(\d+);/g, (_, dec) => String.fromCodePoint(parseInt(dec, 10)))
+    .replace(/&lt;/g, "<").replace(/&gt;/g, ">").replace(/&quot;/g, '"').replace(/&apos;/g, "'")
+    .replace(/&amp;/g, "&");
+}
+
+// Index just past the element that opens at start, counting nested elements.
+function elementEnd(xml: string, start: number): number {
+  const tag = /<(\/?)[A-Za-z]+[^>]*?(\/?)>/g;
+  tag.lastIndex = start;
+  let depth = 0;
+  for (let m = tag.exec(xml); m; m = tag.exec(xml)) {
+    if (!m[2]) depth += m[1] ? -1 : 1;
+    if (depth === 0) return tag.lastIndex;
+  }
+  return xml.length;
+}
+
+// The top-level keys of an XML plist, each with its value as raw XML. Passing
+// that XML to defaults write keeps dates, data, and nested dicts and arrays
+// exactly as the file has them. Null when the root is not a dict.
+export function plistEntries(xml: string): [string, string][] | null {
+  if (/<plist[^>]*>\s*<dict\/>\s*<\/plist>/.test(xml)) return [];
+  const body = xml.match(/<plist[^>]*>\s*<dict>([\s\S]*)<\/dict>\s*<\/plist>/);
+  if (!body) return null;
+  const inner = body[1];
+  const key = /<key>([\s\S]*?)<\/key>/g;
+  const entries: [string, string][] = [];
+  for (let m = key.exec(inner); m; m = key.exec(inner)) {
+    const start = inner.indexOf("<", key.lastIndex);
+    if (start < 0) break;
+    const end = elementEnd(inner, start);
+    entries.push([unescapeXml(m[1]), inner.slice(start, end)]);
+    key.lastIndex = end;
+  }
+  return entries;
+}
+
+function mergeDefaults(domain: string, absFile: string, currentHost?: boolean): string | null {
+  const converted = Bun.spawnSync(["plutil", "-convert", "xml1", "-o", "-", absFile], { stdout: "pipe", stderr: "pipe" });
+  if (converted.exitCode !== 0) {
+    return `plutil could not convert ${absFile} to XML`;
+  }
+
+  const entries = plistEntries(Buffer.from(converted.stdout).toString());
+  if (!entries) return `${absFile} is not a dictionary of keys`;
+  for (const [key, value] of entries) {
+    const proc = Bun.spawnSync(defaultsCommand(["write", domain, key, value], currentHost));
+    if (proc.exitCode !== 0) {
+      return `defaults write ${key} exited with code ${proc.exitCode}`;
+    }
+  }
+  return null;
+}
+
 export async function exportDefaults(
   defaults: Record<string, string>,
   repoDir: string,
@@ -47,21 +226,18 @@ export async function exportDefaults(
     const base: DefaultsResult = { domain, file, success: false, failed: false, dryRun: false, skipped: false };
 
     if (options.dryRun) {
+      const changed = defaultsChanged(domain, file, absFile, options.defaultsFormat, options.currentHost);
       if (options.verbose) {
-        process.stdout.write(`  ${color("[dry-run]", "yellow")} would export ${domain} → ${file}\n`);
+        const status = changed ? "changed" : "no change";
+        process.stdout.write(`  ${color("[dry-run]", "yellow")} would export ${domain} → ${file} (${status})\n`);
       }
-      results.push({ ...base, success: true, dryRun: true });
+      results.push({ ...base, success: true, dryRun: true, changed });
       continue;
     }
 
     try {
-      if (file.endsWith(".xml")) {
-        const proc = Bun.spawnSync(["defaults", "export", domain, "-"], { stdout: "pipe" });
-        Bun.write(absFile, proc.stdout);
-      } else {
-        const proc = Bun.spawnSync(["defaults", "read", domain], { stdout: "pipe" });
-        Bun.write(absFile, proc.stdout);
-      }
+      const proc = Bun.spawnSync(exportCommand(domain, file, options.defaultsFormat, options.currentHost), { stdout: "pipe" });
+      await Bun.write(absFile, proc.stdout);
 
       if (options.verbose) {
         process.stdout.write(`  ${color("[export]", "green")} ${domain} → ${file}\n`);
@@ -109,7 +285,8 @@ export async function importDefaults(
 
     if (options.dryRun) {
       if (options.verbose) {
-        process.stdout.write(`  ${color("[dry-run]", "yellow")} would import ${file} → ${domain}\n`);
+        const verb = options.defaultsMode === "merge" ? "merge" : "import";
+        process.stdout.write(`  ${color("[dry-run]", "yellow")} would ${verb} ${file} → ${domain}\n`);
       }
       results.push({ ...base, success: true, dryRun: true });
       continue;
@@ -124,8 +301,32 @@ export async function importDefaults(
       continue;
     }
 
+    const invalid = lintPlist(absFile);
+    if (invalid) {
+      process.stderr.write(`  ${color("[error]", "red")} ${domain}: ${invalid}\n`);
+      results.push({ ...base, failed: true, reason: invalid });
+      continue;
+    }
+
     try {
-      const proc = Bun.spawnSync(["defaults", "import", domain, absFile]);
+      if (options.defaultsMode === "merge") {
+        const reason = mergeDefaults(domain, absFile, options.currentHost);
+        if (reason) {
+          if (options.verbose) {
+            process.stdout.write(`  ${color("[error]", "red")} ${domain}: ${reason}\n`);
+          }
+          results.push({ ...base, failed: true, reason });
+          continue;
+        }
+        if (options.verbose) {
+          process.stdout.write(`  ${color("[merge]", "green")} ${file} → ${domain}\n`);
+        }
+        if (options.report) process.stdout.write(`  ${color("✓", "green")} merged ${domain}\n`);
+        results.push({ ...base, success: true });
+        continue;
+      }
+
+      const proc = Bun.spawnSync(defaultsCommand(["import", domain, absFile], options.currentHost));
       if (proc.exitCode !== 0) {
         if (options.verbose) {
           process.stdout.write(`  ${color("[error]", "red")} ${domain}: defaults import failed (exit ${proc.exitCode})\n`);
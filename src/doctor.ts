@@ -0,0 +1,99 @@
+import { color } from "./ui";
+import { parseConfig, resolveComponents, Config } from "./config";
+import { detectOS } from "./utils";
+import { resolve } from "node:path";
+import { accessSync, constants, existsSync } from "node:fs";
+
+export type CheckStatus = "pass" | "warn" | "fail";
+
+export interface DoctorCheck {
+  status: CheckStatus;
+  label: string;
+  detail: string;
+}
+
+function canAccess(path: string, mode: number): boolean {
+  try {
+    accessSync(path, mode);
+    return true;
+  } catch {
+    return false;
+  }
+}
+
+function toolCheck(name: string, missing: CheckStatus, why: string): DoctorCheck {
+  const path = Bun.which(name);
+  return path
+    ? { status: "pass", label: name, detail: path }
+    : { status: missing, label: name, detail: `not found (${why})` };
+}
+
+export async function diagnose(configPath: string = "dot.toml"): Promise<DoctorCheck[]> {
+  const os = detectOS();
+  const checks: DoctorCheck[] = [{ status: "pass", label: "os", detail: `${os} (${process.arch})` }];
+
+  const absConfig = resolve(configPath);
+  let config: Config | null = null;
+  if (!existsSync(absConfig)) {
+    checks.push({ status: "fail", label: "config", detail: `${absConfig} not found` });
+  } else if (!canAccess(absConfig, constants.R_OK)) {
+    checks.push({ status: "fail", label: "config", detail: `${absConfig} is not readable` });
+  } else {
+    try {
+      config = await parseConfig(absConfig);
+      checks.push({ status: "pass", label: "config", detail: absConfig });
+    } catch (e: any) {
+      checks.push({ status: "fail", label: "config", detail: e.message });
+    }
+  }
+
+  const repoDir = resolve(absConfig, "..");
+  if (existsSync(repoDir) && !canAccess(repoDir, constants.W_OK)) {
+    checks.push({ status: "warn", label: "repo", detail: `${repoDir} is not writable (defaults export will fail)` });
+  }
+
+  checks.push(toolCheck("git", "warn", "needed for --repo"));
+
+  const resolved = config ? resolveComponents(config, os) : [];
+  const usesDefaults = resolved.some((c) => c.hasDefaults);
+  if (os === "mac") {
+    checks.push(toolCheck("defaults", usesDefaults ? "fail" : "warn", "needed for macOS defaults"));
+    checks.push(toolCheck("plutil", usesDefaults ? "fail" : "warn", "needed to check and merge defaults files"));
+  } else if (usesDefaults) {
+    checks.push({ status: "warn", label: "defaults", detail: "macOS only; defaults in this config will be skipped" });
+  }
+
+  const managers = new Set(resolved.flatMap((c) => Object.keys(c.install)).filter((m) => m !== "any"));
+  for (const manager of [...managers].sort()) {
+    const path = Bun.which(manager);
+    checks.push(path
+      ? { status: "pass", label: manager, detail: path }
+      : { status: "warn", label: manager, detail: "not found" });
+  }
+
+  for (const c of resolved) {
+    if (c.hasInstall && !c.availableManager) {
+      const tried = Object.keys(c.install).join(", ");
+      checks.push({ status: "fail", label: c.name, detail: `no install manager available (${tried})` });
+    }
+  }
+
+  return checks;
+}
+
+export function printDoctor(checks: DoctorCheck[]): void {
+  const marks: Record<CheckStatus, string> = {
+    pass: color("✓", "green"),
+    warn: color("!", "yellow"),
+    fail: color("✗", "red"),
+  };
+  const width = Math.max(...checks.map((c) => c.label.length));
+
+  process.stdout.write(`\n  ${color("dot doctor", "bold")}\n\n`);
+  for (const c of checks) {
+    process.stdout.write(`  ${marks[c.status]} ${c.label.padEnd(width)}  ${c.detail}\n`);
+  }
+
+  const count = (status: CheckStatus) => checks.filter((c) => c.status === status).length;
+  process.stdout.write(`\n  ${count("pass")} passed, ${count("warn")} warning(s), ${count("fail")} failed\n`);
+}
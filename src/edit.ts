@@ -0,0 +1,57 @@
+import prompts from "prompts";
+import { color } from "./ui";
+import { parseConfig } from "./config";
+import { shellArgv } from "./utils";
+import { resolve } from "node:path";
+
+export function editorCommand(env: Record<string, string | undefined> = process.env): string {
+  return env.VISUAL || env.EDITOR || (process.platform === "win32" ? "notepad" : "vi");
+}
+
+export async function validateConfig(path: string): Promise<string | null> {
+  try {
+    await parseConfig(path);
+    return null;
+  } catch (e: any) {
+    return e.message;
+  }
+}
+
+async function askReopen(): Promise<boolean> {
+  const response = await prompts({
+    type: "confirm",
+    name: "reopen",
+    message: "Reopen the editor?",
+    initial: true,
+  });
+  return response.reopen === true;
+}
+
+export async function editConfig(
+  path: string,
+  reopen: () => Promise<boolean> = askReopen
+): Promise<boolean> {
+  const absPath = resolve(path);
+  const editor = editorCommand();
+
+  while (true) {
+    const child = Bun.spawn(shellArgv(`${editor} ${JSON.stringify(absPath)}`), {
+      stdin: "inherit",
+      stdout: "inherit",
+      stderr: "inherit",
+    });
+    const exitCode = await child.exited;
+    if (exitCode !== 0) {
+      process.stderr.write(`${color("[error]", "red")} ${editor} exited with ${exitCode}\n`);
+      return false;
+    }
+
+    const error = await validateConfig(absPath);
+    if (!error) {
+      process.stdout.write(`  ${color("✓", "green")} ${path} is valid\n`);
+      return true;
+    }
+    process.stderr.write(`${color("[error]", "red")} ${error}\n`);
+    if (!process.stdin.isTTY || !(await reopen())) return false;
+  }
+}
@@ -0,0 +1,81 @@
+import { color } from "./ui";
+import { targetPath } from "./utils";
+import { existsSync, lstatSync, rmSync } from "node:fs";
+
+export interface RunOptions {
+  dryRun: boolean;
+  verbose: boolean;
+  interactive: boolean;
+  report?: boolean;
+  targetPrefix?: string;
+}
+
+export interface GcResult {
+  path: string;
+  ageMs: number;
+  removed: boolean;
+  dryRun: boolean;
+  failed: boolean;
+  reason?: string;
+}
+
+const UNITS: Record<string, number> = {
+  s: 1000,
+  m: 60 * 1000,
+  h: 60 * 60 * 1000,
+  d: 24 * 60 * 60 * 1000,
+};
+
+export function parseAge(value: string): number | null {
+  const match = value.match(/^(\d+)([smhd])$/);
+  if (!match) return null;
+  return parseInt(match[1], 10) * UNITS[match[2]];
+}
+
+export function backupPaths(links: Record<string, string[]>[], prefix?: string): string[] {
+  const paths = new Set<string>();
+  for (const link of links) {
+    for (const targets of Object.values(link)) {
+      for (const target of targets) {
+        try {
+          paths.add(targetPath(target, prefix) + ".dot.bak");
+        } catch {
+          // a ~user this machine doesn't know has no backups here
+        }
+      }
+    }
+  }
+  return [...paths].filter((p) => existsSync(p));
+}
+
+export function collectBackups(
+  links: Record<string, string[]>[],
+  olderThanMs: number,
+  options: RunOptions,
+  now: number = Date.now()
+): GcResult[] {
+  const results: GcResult[] = [];
+
+  for (const path of backupPaths(links, options.targetPrefix)) {
+    const ageMs = now - lstatSync(path).mtimeMs;
+    if (ageMs < olderThanMs) continue;
+    const base: GcResult = { path, ageMs, removed: false, dryRun: false, failed: false };
+
+    if (options.dryRun) {
+      if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} would remove ${path}\n`);
+      results.push({ ...base, dryRun: true });
+      continue;
+    }
+
+    try {
+      rmSync(path, { recursive: true, force: true });
+      if (options.report) process.stdout.write(`    ${color("✓", "green")} removed ${path}\n`);
+      results.push({ ...base, removed: true });
+    } catch (e: any) {
+      process.stderr.write(`  ${color("[error]", "red")} ${path}: ${e.message}\n`);
+      results.push({ ...base, failed: true, reason: e.message });
+    }
+  }
+
+  return results;
+}
@@ -1,10 +1,14 @@
 import { color } from "./ui";
+import { binaryExists, runShell, expandEnv } from "./utils";
 
 export interface RunOptions {
   dryRun: boolean;
   verbose: boolean;
   interactive: boolean;
   report?: boolean;
+  shell?: string;
+  env?: Record<string, string>;
+  expandDryRun?: boolean;
 }
 
 export interface HookResult {
@@ -13,84 +17,107 @@ export interface HookResult {
   failed: boolean;
   dryRun: boolean;
   skipped: boolean;
+  failedStep?: number;
+  output?: string;
 }
 
-export async function runPostInstall(
+export type Hook = string | string[];
+
+async function execHook(hook: string, options: RunOptions): Promise<{ exitCode: number; stdout: Buffer; stderr: Buffer }> {
+  if (!options.shell || process.platform === "win32") {
+    const command = Bun.$`${{ raw: hook }}`.nothrow().quiet();
+    return await (options.env ? command.env({ ...process.env, ...options.env }) : command);
+  }
+  if (!binaryExists(options.shell)) {
+    return { exitCode: 127, stdout: Buffer.alloc(0), stderr: Buffer.from(`shell not found: ${options.shell}`) };
+  }
+  return await runShell(hook, options.shell, options.interactive ? "inherit" : "ignore", options.env);
+}
+
+async function runHook(
+  kind: string,
   component: string,
-  hook: string | null | undefined,
+  hook: Hook | null | undefined,
   options: RunOptions
 ): Promise<HookResult> {
   const base: HookResult = { component, success: false, failed: false, dryRun: false, skipped: false };
+  const steps = Array.isArray(hook) ? hook : hook ? [hook] : [];
 
-  if (!hook) {
+  if (steps.length === 0) {
     return { ...base, success: true, skipped: true };
   }
 
   if (options.dryRun) {
-    if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} ${component} postinstall: ${hook}\n`);
+    if (options.report) {
+      for (const step of steps) {
+        const preview = options.expandDryRun ? expandEnv(step, { ...process.env, ...options.env }) : step;
+        process.stdout.write(`  ${color("[dry-run]", "yellow")} ${component} ${kind}: ${preview}\n`);
+      }
+    }
     return { ...base, success: true, dryRun: true };
   }
 
-  if (options.verbose) {
-    process.stdout.write(`  ${color("[postinstall]", "blue")} ${component}: ${hook}\n`);
-  }
+  // stdout and stderr of every step that ran, so reports can show them.
+  let output = "";
+  const withOutput = (result: HookResult): HookResult => (output ? { ...result, output } : result);
 
-  try {
-    const result = await Bun.$`${{ raw: hook }}`.nothrow().quiet();
-    if (result.exitCode !== 0) {
-      const stderr = result.stderr.toString();
-      if (stderr) {
-        process.stderr.write(`  ${color("[error]", "red")} ${component}: ${stderr.trim()}\n`);
-      }
-      return { ...base, failed: true };
+  for (let i = 0; i < steps.length; i++) {
+    const step = steps[i];
+    if (options.verbose) {
+      process.stdout.write(`  ${color(`[${kind}]`, "blue")} ${component}: ${step}\n`);
     }
-  } catch (e: any) {
-    if (e.exitCode !== undefined && e.exitCode !== 0) {
-      return { ...base, failed: true };
+
+    try {
+      const result = await execHook(step, options);
+      output += result.stdout.toString() + result.stderr.toString();
+      if (result.exitCode !== 0) {
+        const stderr = result.stderr.toString();
+        const where = steps.length > 1 ? ` (step ${i + 1}/${steps.length})` : "";
+        if (stderr) {
+          process.stderr.write(`  ${color("[error]", "red")} ${component}${where}: ${stderr.trim()}\n`);
+        }
+        return withOutput({ ...base, failed: true, failedStep: i + 1 });
+      }
+    } catch (e: any) {
+      if (e.exitCode !== undefined && e.exitCode !== 0) {
+        output += `${e.stdout ?? ""}${e.stderr ?? ""}`;
+        return withOutput({ ...base, failed: true, failedStep: i + 1 });
+      }
+      throw e;
     }
-    throw e;
   }
 
-  if (options.report) process.stdout.write(`    ${color("✓", "green")} postinstall\n`);
-  return { ...base, success: true };
+  if (options.report) process.stdout.write(`    ${color("✓", "green")} ${kind}\n`);
+  return withOutput({ ...base, success: true });
 }
 
-export async function runPostLink(
+export async function runPostInstall(
   component: string,
-  hook: string | null | undefined,
+  hook: Hook | null | undefined,
   options: RunOptions
 ): Promise<HookResult> {
-  const base: HookResult = { component, success: false, failed: false, dryRun: false, skipped: false };
-
-  if (!hook) {
-    return { ...base, success: true, skipped: true };
-  }
-
-  if (options.dryRun) {
-    if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} ${component} postlink: ${hook}\n`);
-    return { ...base, success: true, dryRun: true };
-  }
+  return runHook("postinstall", component, hook, options);
+}
 
-  if (options.verbose) {
-    process.stdout.write(`  ${color("[postlink]", "blue")} ${component}: ${hook}\n`);
-  }
+export async function runPostLink(
+  component: string,
+  hook: Hook | null | undefined,
+  options: RunOptions
+): Promise<HookResult> {
+  return runHook("postlink", component, hook, options);
+}
 
-  try {
-    const result = await Bun.$`${{ raw: hook }}`.nothrow().quiet();
-    if (result.exitCode !== 0) {
-      const stderr = result.stderr.toString();
-      if (stderr) {
-        process.stderr.write(`  ${color("[error]", "red")} ${component}: ${stderr.trim()}\n`);
-      }
-      return { ...base, failed: true };
-    }
-  } catch (e: any) {
-    if (e.exitCode !== undefined && e.exitCode !== 0) {
-      return { ...base, failed: true };
-    }
-    throw e;
-  }
+export async function runPreUninstall(
+  component: string,
+  hook: Hook | null | undefined,
+  options: RunOptions
+): Promise<HookResult> {
+  return runHook("preuninstall", component, hook, options);
+}
 
-  if (options.report) process.stdout.write(`    ${color("✓", "green")} postlink\n`);
-  return { ...base, success: true };
+export async function runPostRun(
+  hook: Hook | null | undefined,
+  options: RunOptions
+): Promise<HookResult> {
+  return runHook("postrun", "dot", hook, options);
 }
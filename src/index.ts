@@ -1,13 +1,25 @@
 import { parseArgs } from "./cli";
-import { parseConfig, resolveComponents } from "./config";
+import { parseConfig, resolveComponents, displayName, Component, ResolvedComponent, Config, runRank } from "./config";
 import { resolveComponentNames } from "./fuzzy";
-import { runInteractive } from "./interactive";
-import { installComponent, uninstallComponent } from "./installer";
-import { createLinks } from "./linker";
-import { runPostInstall, runPostLink } from "./hooks";
-import { exportDefaults, importDefaults } from "./defaults";
+import { runInteractive, confirmOnTerminal } from "./interactive";
+import { installComponent, uninstallComponent, effectiveRetries, RunOptions } from "./installer";
+import { createLinks, removeLinks, rollbackLinks, managedLinks, LinkResult, ManagedLink } from "./linker";
+import { runPostInstall, runPostLink, runPostRun, runPreUninstall, HookResult } from "./hooks";
+import { exportDefaults, importDefaults, setDefaults, DefaultsMode, DefaultsFormat, DefaultsResult } from "./defaults";
 import { selfUpgrade } from "./upgrade";
-import { detectOS } from "./utils";
+import { syncRepo, changedFiles, changedSections, componentsForPaths, requireGit } from "./repo";
+import { diagnose, printDoctor } from "./doctor";
+import { ComponentOutcome, computeMetrics, printMetrics, outcomeChanged } from "./metrics";
+import { writeReport } from "./report";
+import { auditRecord, appendAudit } from "./audit";
+import { CompactProgress, interruptOnWrite } from "./compact";
+import { planBatches, runBatches } from "./batch";
+import { editConfig } from "./edit";
+import { configSchema } from "./schema";
+import { writeSnapshot, readSnapshot } from "./snapshot";
+import { collectBackups, parseAge } from "./gc";
+import { detectOS, commandText, displayTarget } from "./utils";
+import { resolve } from "node:path";
 import { color } from "./ui";
 import { showCursor, clearScreen } from "./renderer";
 import { openTerminalInput } from "./terminal";
@@ -21,25 +33,85 @@ function printHelp(): void {
   Usage:
     dot                          Interactive checklist (default)
     dot [flags...]
+    dot <command> [names...] [flags...]
+
+  Commands:
+    install <name...>            Same as -i for each name
+    uninstall [name...]          Same as -u for each name
+    purge <name...>              Same as --purge for each name
+    link [name...]               Same as -l for each name
+    postinstall [name...]        Same as --postinstall for each name
+    postlink [name...]           Same as --postlink for each name
+    defaults export|import       Same as -e / -I
+    list                         Same as --list
+    list-links [name...]         Same as --list-links
+    doctor                       Same as --doctor
+    gc                           Same as --gc
+    edit                         Same as --edit
 
   Actions (combinable, repeatable):
     -i, --install <name>         Run a component's full setup (fuzzy match)
     -u, --uninstall <name>       Uninstall component
+    --purge <name>               Uninstall component and remove its links
     -l, --link <name>            Link files for component
     --postinstall <name>         Run postinstall hooks
     --postlink <name>            Run postlink hooks
     -e, --defaults-export        Export macOS defaults
     -I, --defaults-import        Import macOS defaults
     --list                       List all components
+    --list --json                List components as JSON
+    --list-links [name...]       Print every managed link as target -> source
+    --list-links --broken        Only links that are missing or point elsewhere
+    --doctor                     Check the environment and config for problems
+    --gc                         Remove old .dot.bak backups next to link targets
+    --edit                       Open dot.toml in $EDITOR and validate it on exit
+    --snapshot <file>            Write the components set up on this machine
+                                 (.json, otherwise YAML)
+    --apply <file>               Install exactly the components in a snapshot
+    --components-from-git-diff <base>
+                                 Install the components whose link or defaults
+                                 sources changed since <base> (git diff)
     --upgrade                    Self-upgrade binary
 
   Modifiers:
     --dry-run                    Preview only
     -v, --verbose                Verbose output
+    --expand-dry-run             Dry run that shows commands with $VARS expanded
+                                 ($(...) and backticks stay literal)
+    --repo <url>                 Clone or pull a dotfiles repo and use its dot.toml
+    --repo-ref <ref>             Branch or tag to check out with --repo
+    --target-prefix <dir>        Prepend <dir> to every link target
+    --report <file>              Write a run report (.md, or .html for HTML)
+    --audit-file <file>          Append a one-line JSON summary of the run
+    --log-max-size <size>        Rotate the audit file once it reaches <size>
+                                 (e.g. 10MB); old files are gzipped
+    --log-keep <n>               Rotated audit files to keep (default 5)
+    --gc-older-than <age>        Minimum backup age for --gc (default 30d)
+    --only-restricted            Only use components with an os list matching this OS
+    --disable-manager <name>     Never pick this package manager (repeatable)
+    --compact                    One updating status line for -i instead of a section each
+    --max-retries <n>            Default retries for every component; caps any
+                                 component that sets a higher retries
+    --batch-installs             Combine installs that share a command prefix
+                                 (e.g. brew install) into one call
+    --no-replace                 Never replace an existing file or a symlink
+                                 pointing elsewhere; report it as blocked
+    --adopt                      Turn existing files identical to their source into
+                                 links without a backup
+    --force-uninstall            Uninstall even if a preuninstall hook fails
+    -y, --yes                    Don't ask before uninstalling or purging
+    --require-git                Refuse to run unless dot.toml is in a git repo
+                                 and committed
+    --allow-dirty                With --require-git, allow uncommitted dot.toml changes
+    --fail-fast                  Stop at the first failure
+    --rollback-on-failure        Remove links a failed component created this run
+    --changed-exit               Exit 0 if nothing changed, 10 if changes were
+                                 applied, 2 on failure
 
   Meta:
     -h, --help                   Show this help
     --version                    Show version
+    --schema                     Print the JSON Schema for dot.toml
 
   Examples:
     dot -i zsh -i nvim -v        Install zsh + nvim, verbose
@@ -56,27 +128,257 @@ function printVersion(): void {
 }
 
 function printList(resolved: ReturnType<typeof resolveComponents>): void {
-  process.stdout.write(`\n  Available components:\n\n`);
-  for (const c of resolved) {
-    const mgr = c.availableManager || (c.hasDefaults ? "defaults" : c.hasLinks ? "link-only" : "none");
-    const mgrColor = c.availableManager && c.availableManager !== "any" ? "green"
+  const paint = process.stdout.isTTY ? color : (str: string, _c: string) => str;
+  const width = Math.max(20, ...resolved.map((c) => c.name.length + 1));
+  const rows = resolved.map((c) => ({
+    name: c.name,
+    mgr: c.availableManager || (c.hasDefaults ? "defaults" : c.hasLinks ? "link-only" : "none"),
+    mgrColor: c.availableManager && c.availableManager !== "any" ? "green"
       : c.availableManager === "any" ? "yellow"
-      : "red";
-    process.stdout.write(`  ${color(c.name.padEnd(20), "bold")} ${color(`[${mgr}]`, mgrColor)}\n`);
+      : "red",
+  }));
+  const usable = rows.filter((r) => r.mgr !== "none");
+  const unusable = rows.filter((r) => r.mgr === "none");
+
+  process.stdout.write(`\n  Available components:\n\n`);
+  for (const r of [...usable, ...unusable]) {
+    process.stdout.write(`  ${paint(r.name.padEnd(width), "bold")} ${paint(`[${r.mgr}]`, r.mgrColor)}\n`);
   }
   process.stdout.write(`\n`);
 }
 
-function printComponentStart(name: string): void {
-  process.stdout.write(`\n  ${color(name, "bold")}\n`);
+function printLinks(links: ManagedLink[]): void {
+  for (const l of links) {
+    const state = l.state === "ok" ? "" : ` ${color(`[${l.state}]`, "red")}`;
+    process.stdout.write(`  ${l.target} -> ${l.source}${state}\n`);
+  }
+}
+
+function listInventory(resolved: ResolvedComponent[], maxRetries?: number) {
+  return resolved.map((c) => ({
+    name: c.name,
+    description: c.description ?? null,
+    os: c.os ?? null,
+    manager: c.availableManager,
+    maxAttempts: effectiveRetries(c.retries, maxRetries) + 1,
+    actions: {
+      install: c.hasInstall,
+      uninstall: Object.keys(c.uninstall).length > 0,
+      link: c.hasLinks,
+      defaults: c.hasDefaults,
+      postinstall: Boolean(c.postinstall),
+      postlink: Boolean(c.postlink),
+      preuninstall: Boolean(c.preuninstall),
+    },
+    links: c.link,
+    installed: c.isInstalled,
+    linked: c.allLinksDone,
+  }));
+}
+
+export function uninstallPlan(
+  uninstall: ResolvedComponent[],
+  purge: ResolvedComponent[],
+  prefix?: string
+): string[] {
+  const lines: string[] = [];
+  for (const comp of [...uninstall, ...purge]) {
+    const steps: string[] = [];
+    if (comp.preuninstall) steps.push(`preuninstall: ${[comp.preuninstall].flat().join(" && ")}`);
+    if (comp.uninstallCommand) steps.push(`run: ${comp.uninstallCommand}`);
+    if (purge.includes(comp)) {
+      for (const [src, targets] of Object.entries(comp.link)) {
+        for (const target of targets) steps.push(`remove link: ${displayTarget(target, prefix)} → ${src}`);
+      }
+    }
+    if (steps.length === 0) continue;
+    lines.push(`  ${color(comp.name, "bold")}`, ...steps.map((step) => `    ${step}`));
+  }
+  return lines;
+}
+
+function inRunOrder(found: string[], resolved: ResolvedComponent[]): string[] {
+  const rank = (name: string) => runRank(resolved.find((c) => c.name === name)!);
+  return [...found].sort((a, b) => rank(a) - rank(b));
+}
+
+function printComponentStart(comp: Component): void {
+  process.stdout.write(`\n  ${color(displayName(comp), "bold")}\n`);
+}
+
+function withShell(options: RunOptions, comp: Component): RunOptions {
+  return comp.shell ? { ...options, shell: comp.shell } : options;
+}
+
+function withDefaultsMode(
+  options: RunOptions,
+  comp: Component
+): RunOptions & { defaultsMode?: DefaultsMode; defaultsFormat?: DefaultsFormat } {
+  return { ...options, defaultsMode: comp.defaultsMode, defaultsFormat: comp.defaultsFormat };
+}
+
+function withInstallChecks(options: RunOptions, comp: Component): RunOptions {
+  return { ...withShell(options, comp), successIf: comp.successIf, failIf: comp.failIf, retries: comp.retries,
+    retryBackoff: comp.retryBackoff, retryDelay: comp.retryDelay };
+}
+
+async function importComponentDefaults(comp: Component, options: RunOptions): Promise<DefaultsResult[]> {
+  const opts = withDefaultsMode(options, comp);
+  return [
+    ...(await importDefaults(comp.defaults, process.cwd(), opts)),
+    ...(await importDefaults(comp.defaultsCurrentHost ?? {}, process.cwd(), { ...opts, currentHost: true })),
+  ];
+}
+
+async function exportComponentDefaults(comp: Component, options: RunOptions): Promise<DefaultsResult[]> {
+  const opts = withDefaultsMode(options, comp);
+  return [
+    ...(await exportDefaults(comp.defaults, process.cwd(), opts)),
+    ...(await exportDefaults(comp.defaultsCurrentHost ?? {}, process.cwd(), { ...opts, currentHost: true })),
+  ];
+}
+
+function withHookEnv(options: RunOptions, comp: Component, installRan: boolean, linkChanged: boolean) {
+  return {
+    ...withShell(options, comp),
+    env: { DOT_INSTALL_RAN: String(installRan), DOT_LINK_CHANGED: String(linkChanged) },
+  };
+}
+
+function withLinkOptions(
+  options: RunOptions,
+  comp: Component
+): RunOptions & { allowMissingSource?: boolean; elevated?: boolean } {
+  return { ...options, allowMissingSource: comp.allowMissingSource, elevated: comp.elevated };
+}
+
+function printAlreadyPresent(name: string): void {
+  process.stdout.write(`  ${color("[skip]", "dim")} ${name}: already present\n`);
+}
+
+function gateSkipped(comp: ResolvedComponent): boolean {
+  if (!comp.skipReason) return false;
+  process.stdout.write(`  ${color("[skip]", "dim")} ${comp.name}: ${comp.skipReason}\n`);
+  return true;
+}
+
+async function setupComponent(
+  comp: ResolvedComponent,
+  os: string,
+  options: RunOptions,
+  rollback: boolean = false,
+  batchedCommand?: string
+): Promise<ComponentOutcome> {
+  const startedAt = Date.now();
+  const outcome: ComponentOutcome = {
+    name: comp.name,
+    installed: false,
+    linksCreated: 0,
+    linksCorrect: 0,
+    hooksRun: 0,
+    failed: false,
+    durationMs: 0,
+  };
+  const finish = (failed: boolean): ComponentOutcome => ({ ...outcome, failed, durationMs: Date.now() - startedAt });
+  const keepOutput = (hook: string, result: HookResult) => {
+    if (result.output) (outcome.hookOutput ??= []).push({ hook, output: result.output });
+  };
+  let created: LinkResult[] = [];
+  const fail = (): ComponentOutcome => {
+    if (rollback && created.length > 0) {
+      rollbackLinks(comp.name, created, withLinkOptions(options, comp));
+      outcome.linksCreated = 0;
+      outcome.rolledBack = true;
+    }
+    return finish(true);
+  };
+
+  if (comp.installCommand && comp.isInstalled) {
+    if (options.report) printAlreadyPresent(comp.name);
+  } else if (batchedCommand) {
+    outcome.command = batchedCommand;
+    outcome.installed = !options.dryRun;
+    if (options.report && !options.dryRun) process.stdout.write(`    ${color("✓", "green")} installed in batch\n`);
+  } else if (comp.installCommand) {
+    outcome.command = commandText(comp.installCommand);
+    const result = await installComponent(comp.name, comp.installCommand, withInstallChecks(options, comp), comp.availableManager || undefined);
+    outcome.attempts = result.attempts;
+    if (result.failed && !result.dryRun) return finish(true);
+    outcome.installed = !result.dryRun;
+  }
+  if (comp.hasDefaults && os === "mac") {
+    const results = await importComponentDefaults(comp, options);
+    if (results.some((result) => result.failed && !result.dryRun)) return finish(true);
+  }
+  if (os === "mac") {
+    const results = await setDefaults(comp.defaultsSet, options);
+    if (results.some((result) => result.failed && !result.dryRun)) return finish(true);
+  }
+  if (comp.hasLinks) {
+    const results = createLinks(comp.name, comp.link, process.cwd(), withLinkOptions(options, comp));
+    created = results.filter((r) => r.success && !r.skipped && !r.dryRun);
+    outcome.linksCreated = created.length;
+    outcome.linksCorrect = results.filter((r) => r.success && r.skipped).length;
+    if (results.some((result) => result.failed && !result.dryRun)) return fail();
+  }
+  if (comp.postinstall) {
+    const result = await runPostInstall(comp.name, comp.postinstall, withHookEnv(options, comp, outcome.installed, outcome.linksCreated > 0));
+    keepOutput("postinstall", result);
+    if (result.failed && !result.dryRun) return fail();
+    if (!result.dryRun) outcome.hooksRun++;
+  }
+  if (comp.postlink) {
+    const result = await runPostLink(comp.name, comp.postlink, withHookEnv(options, comp, outcome.installed, outcome.linksCreated > 0));
+    keepOutput("postlink", result);
+    if (result.failed && !result.dryRun) return fail();
+    if (!result.dryRun) outcome.hooksRun++;
+  }
+  return finish(false);
+}
+
+async function runPreUninstallHook(comp: Component, options: RunOptions, force: boolean): Promise<boolean> {
+  if (!comp.preuninstall) return true;
+  const result = await runPreUninstall(comp.name, comp.preuninstall, withShell(options, comp));
+  if (!result.failed) return true;
+  if (force) {
+    process.stdout.write(`  ${color("[warn]", "yellow")} ${comp.name}: preuninstall failed, continuing (--force-uninstall)\n`);
+    return true;
+  }
+  process.stderr.write(`  ${color("[error]", "red")} ${comp.name}: preuninstall failed\n`);
+  return false;
 }
 
+async function runPostRunHook(config: Config, changed: boolean, options: RunOptions): Promise<boolean> {
+  if (!config.postrun) return true;
+  if (!changed && !config.postrunAlways) {
+    if (options.verbose) {
+      process.stdout.write(`  ${color("[skip]", "dim")} postrun: nothing changed\n`);
+    }
+    return true;
+  }
+  process.stdout.write(`\n  ${color("postrun", "bold")}\n`);
+  const result = await runPostRun(config.postrun, options);
+  return !(result.failed && !result.dryRun);
+}
+
+// Exit code for failures, 2 under --changed-exit. Module-level so the Fatal
+// handler uses the parsed flag.
+let failCode = 1;
+
 export async function main(): Promise<void> {
+  const startedAt = Date.now();
   const args = parseArgs(process.argv);
+  failCode = args.changedExit ? 2 : 1;
+  const reportPath = args.reportFile ? resolve(args.reportFile) : null;
+  const auditPath = args.auditFile ? resolve(args.auditFile) : null;
 
   if (args.mode === "meta") {
     if (args.meta === "help") { printHelp(); return; }
     if (args.meta === "version") { printVersion(); return; }
+    if (args.meta === "schema") {
+      process.stdout.write(JSON.stringify(configSchema(), null, 2) + "\n");
+      return;
+    }
     if (args.meta === "upgrade") {
       await selfUpgrade();
       return;
@@ -84,16 +386,46 @@ export async function main(): Promise<void> {
     return;
   }
 
+  if (args.repo) {
+    try {
+      process.chdir(syncRepo(args.repo, { ref: args.repoRef, dryRun: args.dryRun, verbose: args.verbose }));
+    } catch (e: any) {
+      process.stderr.write(`${color("[error]", "red")} ${e.message}\n`);
+      process.exit(failCode);
+    }
+  }
+
+  if (args.edit) {
+    if (!(await editConfig("dot.toml"))) process.exit(failCode);
+    return;
+  }
+
+  if (args.doctor) {
+    const checks = await diagnose("dot.toml");
+    printDoctor(checks);
+    if (checks.some((c) => c.status === "fail")) process.exit(failCode);
+    return;
+  }
+
+  if (args.requireGit) {
+    const problem = requireGit("dot.toml", args.allowDirty);
+    if (problem) {
+      process.stderr.write(`${color("[error]", "red")} ${problem}\n`);
+      process.exit(failCode);
+    }
+  }
+
   let config;
   try {
     config = await parseConfig("dot.toml");
   } catch (e: any) {
     process.stderr.write(`${color("[error]", "red")} ${e.message}\n`);
-    process.exit(1);
+    process.exit(failCode);
   }
 
-  const os = detectOS();
-  const resolved = resolveComponents(config, os);
+  const os = args.simulateOS || detectOS();
+  const resolved = resolveComponents(config, os, args.disabledManagers, args.targetPrefix || undefined)
+    .filter((c) => !args.onlyRestricted || (c.os !== undefined && c.os.length > 0));
 
   if (resolved.length === 0) {
     process.stdout.write(`${color("[warn]", "yellow")} No components found in config for this OS\n`);
@@ -107,7 +439,7 @@ export async function main(): Promise<void> {
     if (!isTty) {
       if (!terminalInput) {
         process.stderr.write(`${color("[error]", "red")} Interactive mode requires a terminal. Use --install, --link, or --dry-run flags for non-interactive use.\n`);
-        process.exit(1);
+        process.exit(failCode);
       }
     }
 
@@ -122,70 +454,190 @@ export async function main(): Promise<void> {
     }
 
     const action = args.interactiveAction;
-    const options = { dryRun: args.dryRun, verbose: args.verbose, interactive: true, report: true };
-
+    const options = {
+      dryRun: args.dryRun,
+      verbose: args.verbose,
+      interactive: true,
+      report: true,
+      targetPrefix: args.targetPrefix || undefined,
+      maxRetries: args.maxRetries ?? undefined,
+      noReplace: args.noReplace,
+      adopt: args.adopt,
+      expandDryRun: args.expandDryRun,
+    };
+    let changed = false;
+
+    const runOrder = inRunOrder(selected.map((item) => item.name), resolved);
+    selected.sort((a, b) => runOrder.indexOf(a.name) - runOrder.indexOf(b.name));
     for (const item of selected) {
       if (item.unavailable) continue;
       const comp = resolved.find((c: { name: string }) => c.name === item.name);
       if (!comp) continue;
+      if (action !== "uninstall" && gateSkipped(comp)) continue;
 
       if (!action || action === "install") {
-        if (comp.installCommand) {
-          const result = await installComponent(comp.name, comp.installCommand, options, comp.availableManager || undefined);
-          if (result.failed) {
-            process.stderr.write(`  ${color("[error]", "red")} ${comp.name}: install failed\n`);
-          }
-        }
-      }
-
-      if (!action || action === "install") {
-        if (comp.hasDefaults && os === "mac") {
-          await importDefaults(comp.defaults, process.cwd(), options);
+        // The same steps as direct mode, so a component's rollback = true
+        // holds here too.
+        const outcome = await setupComponent(comp, os, options, comp.rollback === true);
+        if (outcome.failed) {
+          const undone = outcome.rolledBack ? "; its links were rolled back" : "";
+          process.stderr.write(`  ${color("[error]", "red")} ${displayName(comp)}: setup failed${undone}\n`);
         }
+        if (outcome.installed || outcome.linksCreated > 0) changed = true;
+        continue;
       }
 
-      if (!action || action === "install" || action === "link") {
-        if (comp.hasLinks) {
-          createLinks(comp.name, comp.link, process.cwd(), options);
-        }
+      let linkChanged = false;
+      if (action === "link" && comp.hasLinks) {
+        const results = createLinks(comp.name, comp.link, process.cwd(), withLinkOptions(options, comp));
+        linkChanged = results.some((r) => r.success && !r.skipped && !r.dryRun);
+        if (linkChanged) changed = true;
       }
 
-      if (!action || action === "install" || action === "postinstall") {
-        if (comp.postinstall) {
-          await runPostInstall(comp.name, comp.postinstall, options);
-        }
+      if (action === "postinstall" && comp.postinstall) {
+        await runPostInstall(comp.name, comp.postinstall, withHookEnv(options, comp, false, linkChanged));
       }
 
-      if (!action || action === "install" || action === "postlink") {
-        if (comp.postlink) {
-          await runPostLink(comp.name, comp.postlink, options);
-        }
+      if (action === "postlink" && comp.postlink) {
+        await runPostLink(comp.name, comp.postlink, withHookEnv(options, comp, false, linkChanged));
       }
 
       if (action === "uninstall") {
-        const uninstallCmd = Object.entries(comp.uninstall)[0];
-        if (uninstallCmd) {
-          const [, cmd] = uninstallCmd;
-          await uninstallComponent(comp.name, cmd, options);
+        if (comp.uninstallCommand && await runPreUninstallHook(comp, options, args.forceUninstall)) {
+          const result = await uninstallComponent(comp.name, comp.uninstallCommand, withShell(options, comp));
+          if (result.success && !result.dryRun) changed = true;
         }
       }
     }
 
+    if (!(await runPostRunHook(config, changed, options))) {
+      process.stderr.write(`  ${color("[error]", "red")} postrun failed\n`);
+      process.exit(failCode);
+    }
+
+    if (args.changedExit && changed) process.exit(10);
     return;
   }
 
   if (args.mode === "direct") {
-    const options = { dryRun: args.dryRun, verbose: args.verbose, interactive: isTty, report: true };
+    const options = {
+      dryRun: args.dryRun,
+      verbose: args.verbose,
+      interactive: isTty,
+      report: true,
+      targetPrefix: args.targetPrefix || undefined,
+      maxRetries: args.maxRetries ?? undefined,
+      noReplace: args.noReplace,
+      adopt: args.adopt,
+      expandDryRun: args.expandDryRun,
+    };
     const names = resolved.map((c: { name: string }) => c.name);
 
     if (args.list) {
-      printList(resolved);
+      if (args.json) {
+        process.stdout.write(JSON.stringify(listInventory(resolved, options.maxRetries), null, 2) + "\n");
+      } else {
+        printList(resolved);
+      }
+      return;
+    }
+
+    if (args.listLinks) {
+      const { found, missing } = args.linkFilter.length > 0
+        ? resolveComponentNames(args.linkFilter, names)
+        : { found: names, missing: [] };
+      for (const m of missing) {
+        process.stdout.write(`  ${color("[warn]", "yellow")} component not found: ${m}\n`);
+      }
+      const links = resolved
+        .filter((c) => found.includes(c.name))
+        .flatMap((c) => managedLinks(c.name, c.link, process.cwd(), options.targetPrefix))
+        .filter((l) => !args.broken || l.state !== "ok");
+      if (args.json) {
+        process.stdout.write(JSON.stringify(links, null, 2) + "\n");
+      } else {
+        printLinks(links);
+      }
       return;
     }
 
+    if (args.snapshotFile) {
+      const snapshot = writeSnapshot(args.snapshotFile, resolved);
+      process.stdout.write(`  ${color("✓", "green")} wrote ${snapshot.length} components to ${args.snapshotFile}\n`);
+      return;
+    }
+
+    // Names from --apply and --components-from-git-diff are exact and skip
+    // fuzzy matching, which would also pick up gitui for git.
+    const exactInstall: string[] = [];
+
+    if (args.applyFile) {
+      let applied: string[];
+      try {
+        applied = readSnapshot(args.applyFile);
+      } catch (e: any) {
+        process.stderr.write(`${color("[error]", "red")} ${e.message}\n`);
+        process.exit(failCode);
+      }
+      const unknown = applied.filter((n) => !names.includes(n));
+      if (unknown.length > 0) {
+        process.stderr.write(
+          `${color("[error]", "red")} ${args.applyFile} lists components not in dot.toml for this OS: ${unknown.join(", ")}\n`
+        );
+        process.exit(failCode);
+      }
+      if (applied.length === 0 && !args.install.length) {
+        process.stdout.write(`  Nothing to apply from ${args.applyFile}\n`);
+        return;
+      }
+      exactInstall.push(...applied);
+    }
+
+    if (args.gitDiffBase) {
+      let changedComponents: string[];
+      try {
+        const files = changedFiles(args.gitDiffBase);
+        changedComponents = componentsForPaths(resolved, files);
+        if (files.includes("dot.toml")) {
+          const { sections, settings } = changedSections(args.gitDiffBase);
+          for (const name of sections) {
+            if (resolved.some((c) => c.name === name) && !changedComponents.includes(name)) changedComponents.push(name);
+          }
+          if (settings.length > 0) {
+            process.stderr.write(
+              `  ${color("[warn]", "yellow")} dot.toml settings changed since ${args.gitDiffBase} (${settings.join(", ")}); ` +
+                `they can affect components not selected here\n`
+            );
+          }
+        }
+      } catch (e: any) {
+        process.stderr.write(`${color("[error]", "red")} ${e.message}\n`);
+        process.exit(failCode);
+      }
+      if (changedComponents.length === 0 && !args.install.length) {
+        process.stdout.write(`  No components changed since ${args.gitDiffBase}\n`);
+        return;
+      }
+      if (args.verbose) {
+        process.stdout.write(`  ${color("[diff]", "blue")} changed since ${args.gitDiffBase}: ${changedComponents.join(", ")}\n`);
+      }
+      exactInstall.push(...changedComponents.filter((n) => !exactInstall.includes(n)));
+    }
+
+    let gcFailed = false;
+    if (args.gc) {
+      const results = collectBackups(resolved.map((c) => c.link), parseAge(args.gcOlderThan)!, options);
+      if (results.length === 0) {
+        process.stdout.write(`  No backups older than ${args.gcOlderThan}\n`);
+      }
+      gcFailed = results.some((r) => r.failed);
+    }
+
     const hasOnlyModifiers = (
       !args.install.length &&
+      !exactInstall.length &&
       !args.uninstall.length &&
+      !args.purge.length &&
       !args.link.length &&
       !args.postinstall.length &&
       !args.postlink.length &&
@@ -195,11 +647,39 @@ export async function main(): Promise<void> {
     );
 
     if (hasOnlyModifiers) {
+      if (args.gc) {
+        if (gcFailed) process.exit(failCode);
+        return;
+      }
       process.stderr.write(`${color("[error]", "red")} No actions specified. Use --help for usage.\n`);
-      process.exit(1);
+      process.exit(failCode);
     }
 
-    const failures: string[] = [];
+    const failures: string[] = gcFailed ? ["gc"] : [];
+    const notAttempted: string[] = [];
+    const stopped = () => args.failFast && failures.length > 0;
+    const outcomes: ComponentOutcome[] = [];
+    let changed = false;
+
+    const toUninstall = resolveComponentNames(args.uninstall, names).found
+      .map((name) => resolved.find((c) => c.name === name)!);
+    const toPurge = resolveComponentNames(args.purge, names).found
+      .map((name) => resolved.find((c) => c.name === name)!);
+    const plan = uninstallPlan(toUninstall, toPurge, options.targetPrefix);
+    if (plan.length > 0 && (args.dryRun || !args.yes)) {
+      process.stdout.write(`\n  ${color(args.dryRun ? "Uninstall plan (dry run):" : "This will:", "bold")}\n${plan.join("\n")}\n`);
+      if (!args.dryRun) {
+        const ok = await confirmOnTerminal("Uninstall these components?", false, isTty);
+        if (ok === null) {
+          process.stderr.write(`${color("[error]", "red")} Uninstalling needs confirmation and there is no terminal to ask on. Pass --yes to go ahead without asking.\n`);
+          process.exit(failCode);
+        }
+        if (!ok) {
+          process.stdout.write(`  Nothing uninstalled\n`);
+          process.exit(0);
+        }
+      }
+    }
 
     if (args.uninstall.length > 0) {
       const { found, missing } = resolveComponentNames(args.uninstall, names);
@@ -207,85 +687,141 @@ export async function main(): Promise<void> {
         process.stdout.write(`  ${color("[warn]", "yellow")} component not found: ${m}\n`);
       }
       for (const name of found) {
-        printComponentStart(name);
+        if (stopped()) {
+          notAttempted.push(name);
+          continue;
+        }
         const comp = resolved.find((c: { name: string }) => c.name === name)!;
-        const uninstallCmd = Object.entries(comp.uninstall)[0];
-        if (!uninstallCmd) {
+        printComponentStart(comp);
+        if (!comp.uninstallCommand) {
           process.stdout.write(`  ${color("[skip]", "dim")} ${name}: no uninstall command\n`);
           continue;
         }
-        const [, cmd] = uninstallCmd;
-        const result = await uninstallComponent(name, cmd, options);
+        if (!(await runPreUninstallHook(comp, options, args.forceUninstall))) {
+          failures.push(name);
+          continue;
+        }
+        const result = await uninstallComponent(name, comp.uninstallCommand, withShell(options, comp));
         if (result.failed && !result.dryRun) failures.push(name);
+        else if (!result.dryRun) changed = true;
       }
     }
 
-    if (args.install.length > 0) {
-      const { found, missing } = resolveComponentNames(args.install, names);
+    if (args.purge.length > 0) {
+      const { found, missing } = resolveComponentNames(args.purge, names);
       for (const m of missing) {
         process.stdout.write(`  ${color("[warn]", "yellow")} component not found: ${m}\n`);
       }
       for (const name of found) {
-        printComponentStart(name);
+        if (stopped()) {
+          notAttempted.push(name);
+          continue;
+        }
         const comp = resolved.find((c: { name: string }) => c.name === name)!;
-        if (comp.installCommand) {
-          const result = await installComponent(name, comp.installCommand, options, comp.availableManager || undefined);
-          if (result.failed && !result.dryRun) {
-            failures.push(name);
-            continue;
-          }
+        printComponentStart(comp);
+        if ((comp.uninstallCommand || comp.hasLinks) && !(await runPreUninstallHook(comp, options, args.forceUninstall))) {
+          failures.push(name);
+          continue;
         }
-        if (comp.hasDefaults && os === "mac") {
-          const results = await importDefaults(comp.defaults, process.cwd(), options);
-          if (results.some((result) => result.failed && !result.dryRun)) {
+        if (comp.uninstallCommand) {
+          const result = await uninstallComponent(name, comp.uninstallCommand, withShell(options, comp));
+          if (result.failed && !result.dryRun) {
             failures.push(name);
             continue;
           }
+          if (!result.dryRun) changed = true;
         }
         if (comp.hasLinks) {
-          const results = createLinks(name, comp.link, process.cwd(), options);
-          if (results.some((result) => result.failed && !result.dryRun)) {
-            failures.push(name);
-            continue;
-          }
+          const results = removeLinks(name, comp.link, process.cwd(), withLinkOptions(options, comp));
+          if (results.some((r) => r.failed && !r.dryRun)) failures.push(name);
+          if (results.some((r) => r.success && !r.skipped && !r.dryRun)) changed = true;
         }
-        if (comp.postinstall) {
-          const result = await runPostInstall(name, comp.postinstall, options);
-          if (result.failed && !result.dryRun) {
-            failures.push(name);
+      }
+    }
+
+    if (args.install.length > 0 || exactInstall.length > 0) {
+      const { found: matched, missing } = resolveComponentNames(args.install, names);
+      for (const m of missing) {
+        process.stdout.write(`  ${color("[warn]", "yellow")} component not found: ${m}\n`);
+      }
+      const found = [...matched, ...exactInstall.filter((n) => !matched.includes(n))];
+      // Compact mode draws through its own handle on stdout; everything else
+      // written to stdout or stderr ends the status line first. Verbose output
+      // is off there, since it would push the status line off screen anyway.
+      const stdout = process.stdout;
+      const progress = args.compact
+        ? new CompactProgress(found.length, { write: stdout.write.bind(stdout), isTTY: stdout.isTTY })
+        : null;
+      const stepOptions = progress ? { ...options, report: false, verbose: false } : options;
+      const restoreWrites = progress ? interruptOnWrite(progress, [process.stdout, process.stderr]) : null;
+      const batched = new Map<string, string>();
+      let batchedRank = -1;
+      try {
+        for (const name of inRunOrder(found, resolved)) {
+          if (stopped()) {
+            notAttempted.push(name);
             continue;
           }
-        }
-        if (comp.postlink) {
-          const result = await runPostLink(name, comp.postlink, options);
-          if (result.failed && !result.dryRun) {
-            failures.push(name);
+          const comp = resolved.find((c: { name: string }) => c.name === name)!;
+          // Batches are planned one run rank at a time, as that rank starts, so
+          // run_first components are set up before anything is batched and a
+          // --fail-fast stop keeps later ranks from installing.
+          if (args.batchInstalls && runRank(comp) !== batchedRank) {
+            batchedRank = runRank(comp);
+            const rank = resolved.filter((c) => found.includes(c.name) && runRank(c) === batchedRank);
+            const installed = await runBatches(planBatches(rank), stepOptions);
+            for (const [n, command] of installed) batched.set(n, command);
           }
+          if (progress) {
+            progress.start(name);
+            if (comp.skipReason) {
+              progress.finish(name, "skipped");
+              continue;
+            }
+            // sudo prompts on the terminal directly, past the write guard.
+            if (comp.elevated) progress.interrupt();
+          } else {
+            printComponentStart(comp);
+            if (gateSkipped(comp)) continue;
+          }
+          const outcome = await setupComponent(
+            comp,
+            os,
+            stepOptions,
+            args.rollbackOnFailure || comp.rollback === true,
+            batched.get(name)
+          );
+          outcomes.push(outcome);
+          if (outcome.failed) failures.push(name);
+          progress?.finish(name, outcome.failed ? "failed" : "ok");
         }
+      } finally {
+        restoreWrites?.();
+        progress?.end();
       }
     }
 
-    if (args.importDefaults) {
-      const allDefaults = Object.fromEntries(
-        resolved
-          .filter((c: { hasDefaults: boolean }) => c.hasDefaults)
-          .flatMap((c: { defaults: Record<string, string> }) => Object.entries(c.defaults))
-      );
-      const results = await importDefaults(allDefaults, process.cwd(), options);
-      for (const r of results) {
-        if (r.failed && !r.dryRun) failures.push(r.domain);
+    if (args.importDefaults && !stopped()) {
+      for (const comp of resolved.filter((c) => c.hasDefaults)) {
+        const results = await importComponentDefaults(comp, options);
+        for (const r of results) {
+          if (r.failed && !r.dryRun) failures.push(r.domain);
+        }
+      }
+      for (const comp of resolved) {
+        const results = await setDefaults(comp.defaultsSet, options);
+        for (const r of results) {
+          if (r.failed && !r.dryRun) failures.push(r.domain);
+        }
       }
     }
 
-    if (args.exportDefaults) {
-      const allDefaults = Object.fromEntries(
-        resolved
-          .filter((c: { hasDefaults: boolean }) => c.hasDefaults)
-          .flatMap((c: { defaults: Record<string, string> }) => Object.entries(c.defaults))
-      );
-      const results = await exportDefaults(allDefaults, process.cwd(), options);
-      for (const r of results) {
-        if (r.failed && !r.dryRun) failures.push(r.domain);
+    if (args.exportDefaults && !stopped()) {
+      for (const comp of resolved.filter((c) => c.hasDefaults)) {
+        const results = await exportComponentDefaults(comp, options);
+        for (const r of results) {
+          if (r.failed && !r.dryRun) failures.push(r.domain);
+        }
       }
     }
 
@@ -294,14 +830,20 @@ export async function main(): Promise<void> {
       for (const m of missing) {
         process.stdout.write(`  ${color("[warn]", "yellow")} component not found: ${m}\n`);
       }
-      for (const name of found) {
-        printComponentStart(name);
+      for (const name of inRunOrder(found, resolved)) {
+        if (stopped()) {
+          notAttempted.push(name);
+          continue;
+        }
         const comp = resolved.find((c: { name: string }) => c.name === name)!;
+        printComponentStart(comp);
+        if (gateSkipped(comp)) continue;
         if (comp.hasLinks) {
-          const results = createLinks(name, comp.link, process.cwd(), options);
+          const results = createLinks(name, comp.link, process.cwd(), withLinkOptions(options, comp));
           for (const r of results) {
             if (r.failed && !r.dryRun) failures.push(name);
           }
+          if (results.some((r) => r.success && !r.skipped && !r.dryRun)) changed = true;
         }
       }
     }
@@ -311,11 +853,16 @@ export async function main(): Promise<void> {
       for (const m of missing) {
         process.stdout.write(`  ${color("[warn]", "yellow")} component not found: ${m}\n`);
       }
-      for (const name of found) {
-        printComponentStart(name);
+      for (const name of inRunOrder(found, resolved)) {
+        if (stopped()) {
+          notAttempted.push(name);
+          continue;
+        }
         const comp = resolved.find((c: { name: string }) => c.name === name)!;
+        printComponentStart(comp);
+        if (gateSkipped(comp)) continue;
         if (comp.postinstall) {
-          const result = await runPostInstall(name, comp.postinstall, options);
+          const result = await runPostInstall(name, comp.postinstall, withHookEnv(options, comp, false, false));
           if (result.failed && !result.dryRun) failures.push(name);
         }
       }
@@ -326,30 +873,70 @@ export async function main(): Promise<void> {
       for (const m of missing) {
         process.stdout.write(`  ${color("[warn]", "yellow")} component not found: ${m}\n`);
       }
-      for (const name of found) {
-        printComponentStart(name);
+      for (const name of inRunOrder(found, resolved)) {
+        if (stopped()) {
+          notAttempted.push(name);
+          continue;
+        }
         const comp = resolved.find((c: { name: string }) => c.name === name)!;
+        printComponentStart(comp);
+        if (gateSkipped(comp)) continue;
         if (comp.postlink) {
-          const result = await runPostLink(name, comp.postlink, options);
+          const result = await runPostLink(name, comp.postlink, withHookEnv(options, comp, false, false));
           if (result.failed && !result.dryRun) failures.push(name);
         }
       }
     }
 
+    if (outcomes.some(outcomeChanged)) changed = true;
+    if (!stopped() && !(await runPostRunHook(config, changed, options))) failures.push("postrun");
+
+    if (notAttempted.length > 0) {
+      process.stderr.write(`\n  ${color("[skip]", "dim")} not attempted after failure: ${notAttempted.join(", ")}\n`);
+    }
+
+    if (args.verbose && outcomes.length > 0) {
+      printMetrics(computeMetrics(outcomes, startedAt));
+    }
+
+    if (reportPath) {
+      writeReport(reportPath, outcomes, {
+        os,
+        date: new Date(startedAt),
+        metrics: computeMetrics(outcomes, startedAt),
+        failures,
+      });
+    }
+
+    if (auditPath && !args.dryRun) {
+      const metrics = computeMetrics(outcomes, startedAt);
+      const rotation = args.logMaxSize ? { maxBytes: args.logMaxSize, keep: args.logKeep } : undefined;
+      appendAudit(auditPath, auditRecord(outcomes.map((o) => o.name), metrics, failures, VERSION, new Date(startedAt)), rotation);
+    }
+
     if (failures.length > 0) {
       process.stderr.write(`\n${color(`  ${failures.length} failure(s)`, "red")}\n`);
-      process.exit(1);
+      process.exit(failCode);
     }
 
     process.stdout.write(`\n  ${color("✓", "green")} Done.\n`);
+    if (args.changedExit && changed) process.exit(10);
   }
 }
 
+function onInterrupt(signal: NodeJS.Signals): void {
+  if (process.stdout.isTTY) process.stdout.write(showCursor());
+  process.stderr.write(`\n  ${color("interrupted", "yellow")}\n`);
+  process.exit(signal === "SIGINT" ? 130 : 143);
+}
+
 export { VERSION };
 
 if (import.meta.main) {
+  process.on("SIGINT", onInterrupt);
+  process.on("SIGTERM", onInterrupt);
   main().catch((e) => {
     process.stderr.write(`Fatal: ${e.message}\n`);
-    process.exit(1);
+    process.exit(failCode);
   });
 }
@@ -1,10 +1,20 @@
 import { color } from "./ui";
+import { binaryExists, runArgv, runShell, commandText, expandEnv } from "./utils";
 
 export interface RunOptions {
   dryRun: boolean;
   verbose: boolean;
   interactive: boolean;
   report?: boolean;
+  shell?: string;
+  successIf?: string;
+  failIf?: string;
+  retries?: number;
+  maxRetries?: number;
+  retryBackoff?: "fixed" | "exponential";
+  retryDelay?: number;
+  sleep?: (ms: number) => Promise<void>;
+  expandDryRun?: boolean;
 }
 
 export interface RunResult {
@@ -13,28 +23,88 @@ export interface RunResult {
   failed: boolean;
   dryRun: boolean;
   manager?: string;
+  attempts?: number;
 }
 
-async function runNonInteractive(command: string): Promise<{ exitCode: number; stderr: Buffer }> {
-  const shellCommand = process.platform === "win32"
-    ? [process.env.ComSpec || "cmd.exe", "/d", "/s", "/c", command]
-    : [Bun.which("bash") || "/bin/sh", "-c", command];
-  const child = Bun.spawn(shellCommand, {
-    stdin: "ignore",
-    stdout: "pipe",
-    stderr: "pipe",
-  });
-  const [exitCode, stderr] = await Promise.all([
-    child.exited,
-    new Response(child.stderr).arrayBuffer(),
-    new Response(child.stdout).arrayBuffer(),
-  ]);
-  return { exitCode, stderr: Buffer.from(stderr) };
+async function runCommand(
+  command: string | string[],
+  options: RunOptions
+): Promise<{ exitCode: number; stdout: Buffer; stderr: Buffer }> {
+  if (Array.isArray(command)) {
+    return await runArgv(command, options.interactive ? "inherit" : "ignore");
+  }
+  if (options.shell && process.platform !== "win32" && !binaryExists(options.shell)) {
+    return { exitCode: 127, stdout: Buffer.alloc(0), stderr: Buffer.from(`shell not found: ${options.shell}`) };
+  }
+  if (options.interactive) {
+    if (options.shell && process.platform !== "win32") {
+      return await runShell(command, options.shell, "inherit");
+    }
+    return await Bun.$`${{ raw: command }}`.nothrow().quiet();
+  }
+  return await runShell(command, options.shell, "ignore");
+}
+
+export function outputFailure(exitCode: number, output: string, options: RunOptions): string | null {
+  if (options.failIf && new RegExp(options.failIf, "m").test(output)) {
+    return `output matched fail_if /${options.failIf}/`;
+  }
+  if (options.successIf) {
+    return new RegExp(options.successIf, "m").test(output)
+      ? null
+      : `output did not match success_if /${options.successIf}/`;
+  }
+  return exitCode === 0 ? null : `exit code ${exitCode}`;
+}
+
+// --max-retries is the default for components without `retries` and a cap for those with it.
+export function effectiveRetries(retries?: number, maxRetries?: number): number {
+  if (retries === undefined) return maxRetries ?? 0;
+  return maxRetries === undefined ? retries : Math.min(retries, maxRetries);
+}
+
+// Milliseconds to wait after the given (1-based) attempt failed. Exponential
+// backoff doubles the delay each time and adds up to half the base delay of
+// jitter so parallel machines don't hit a mirror in lockstep.
+export function retryDelay(
+  attempt: number,
+  backoff: "fixed" | "exponential" | undefined,
+  baseSeconds: number | undefined,
+  random: () => number = Math.random
+): number {
+  if (backoff === undefined && baseSeconds === undefined) return 0;
+  const base = (baseSeconds ?? 1) * 1000;
+  if (backoff !== "exponential") return base;
+  return base * 2 ** (attempt - 1) + random() * (base / 2);
+}
+
+async function attemptInstall(name: string, command: string | string[], options: RunOptions): Promise<boolean> {
+  try {
+    const result = await runCommand(command, options);
+    const output = result.stdout.toString() + result.stderr.toString();
+    const failure = outputFailure(result.exitCode, output, options);
+    if (failure) {
+      if (options.verbose) {
+        const stderr = result.stderr.toString().trim();
+        if (stderr) process.stderr.write(`  ${color("[error]", "red")} ${name}: ${stderr}\n`);
+      }
+      if (result.exitCode === 0 || options.successIf) {
+        process.stderr.write(`  ${color("[error]", "red")} ${name}: ${failure}\n`);
+      }
+      return false;
+    }
+  } catch (e: any) {
+    if (e.exitCode !== undefined && e.exitCode !== 0) {
+      return false;
+    }
+    throw e;
+  }
+  return true;
 }
 
 export async function installComponent(
   name: string,
-  command: string | null,
+  command: string | string[] | null,
   options: RunOptions,
   manager?: string
 ): Promise<RunResult> {
@@ -45,38 +115,38 @@ export async function installComponent(
   }
 
   if (options.dryRun) {
-    if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} ${name}: ${command}\n`);
+    const preview = options.expandDryRun && typeof command === "string" ? expandEnv(command) : commandText(command);
+    if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} ${name}: ${preview}\n`);
     return { ...base, success: true, dryRun: true };
   }
 
   if (options.verbose) {
-    process.stdout.write(`  ${color("[install]", "blue")} ${name}: ${command}\n`);
+    process.stdout.write(`  ${color("[install]", "blue")} ${name}: ${commandText(command)}\n`);
   }
 
-  try {
-    let result;
-    if (options.interactive) {
-      result = await Bun.$`${{ raw: command }}`.nothrow().quiet();
-    } else {
-      result = await runNonInteractive(command);
+  const maxAttempts = effectiveRetries(options.retries, options.maxRetries) + 1;
+  for (let attempt = 1; attempt <= maxAttempts; attempt++) {
+    if (await attemptInstall(name, command, options)) {
+      if (options.verbose && attempt > 1) {
+        process.stdout.write(`  ${color("[retry]", "yellow")} ${name}: succeeded after ${attempt} attempts\n`);
+      }
+      if (options.report) process.stdout.write(`    ${color("✓", "green")} installed\n`);
+      return { ...base, success: true, attempts: attempt };
     }
-    if (result.exitCode !== 0) {
+    if (attempt < maxAttempts) {
+      const delay = retryDelay(attempt, options.retryBackoff, options.retryDelay);
       if (options.verbose) {
-        const stderr = result.stderr.toString().trim();
-        if (stderr) process.stderr.write(`  ${color("[error]", "red")} ${name}: ${stderr}\n`);
+        const wait = delay > 0 ? ` in ${(delay / 1000).toFixed(1)}s` : "";
+        process.stdout.write(`  ${color("[retry]", "yellow")} ${name}: attempt ${attempt + 1} of ${maxAttempts}${wait}\n`);
       }
-      return { ...base, failed: true };
-    }
-  } catch (e: any) {
-    if (e.exitCode !== undefined && e.exitCode !== 0) {
-      return { ...base, failed: true };
+      if (delay > 0) await (options.sleep ?? Bun.sleep)(delay);
     }
-    throw e;
   }
 
-  if (options.report) process.stdout.write(`    ${color("✓", "green")} installed\n`);
-
-  return { ...base, success: true };
+  if (options.verbose && maxAttempts > 1) {
+    process.stdout.write(`  ${color("[retry]", "yellow")} ${name}: gave up after ${maxAttempts} attempts\n`);
+  }
+  return { ...base, failed: true, attempts: maxAttempts };
 }
 
 export async function uninstallComponent(
@@ -91,7 +161,8 @@ export async function uninstallComponent(
   }
 
   if (options.dryRun) {
-    if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} ${name}: ${command}\n`);
+    const preview = options.expandDryRun ? expandEnv(command) : command;
+    if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} would uninstall ${name}: ${preview}\n`);
     return { ...base, success: true, dryRun: true };
   }
 
@@ -100,12 +171,7 @@ export async function uninstallComponent(
   }
 
   try {
-    let result;
-    if (options.interactive) {
-      result = await Bun.$`${{ raw: command }}`.nothrow().quiet();
-    } else {
-      result = await runNonInteractive(command);
-    }
+    const result = await runCommand(command, options);
     if (result.exitCode !== 0) {
       return { ...base, failed: true };
     }
@@ -1,6 +1,8 @@
 import prompts from "prompts";
 import { color } from "./ui";
 import { ResolvedComponent } from "./config";
+import { commandText } from "./utils";
+import { openTerminalInput } from "./terminal";
 
 export interface CheckboxItem {
   name: string;
@@ -21,7 +23,7 @@ export function buildChecklist(components: ResolvedComponent[]): CheckboxItem[]
     selected: false,
     unavailable: !c.availableManager && !c.hasDefaults && !c.hasLinks,
     manager: c.availableManager,
-    installCommand: c.installCommand,
+    installCommand: c.installCommand ? commandText(c.installCommand) : null,
     hasDefaults: c.hasDefaults,
     hasLinks: c.hasLinks,
     hasInstall: c.hasInstall,
@@ -39,6 +41,33 @@ export function buildChecklist(components: ResolvedComponent[]): CheckboxItem[]
   return items;
 }
 
+export async function confirm(
+  message: string,
+  initial: boolean = true,
+  stdin: NodeJS.ReadStream = process.stdin
+): Promise<boolean> {
+  const response = await prompts({ type: "confirm", name: "ok", message, initial, stdin });
+  return response.ok === true;
+}
+
+// Asks on stdin when it is a terminal, otherwise on the controlling terminal,
+// so piped input is never taken as the answer. Returns null when there is no
+// terminal to ask on.
+export async function confirmOnTerminal(
+  message: string,
+  initial: boolean,
+  isTty: boolean,
+  open: () => NodeJS.ReadStream | null = openTerminalInput
+): Promise<boolean | null> {
+  const terminalInput = isTty ? null : open();
+  if (!isTty && !terminalInput) return null;
+  try {
+    return await confirm(message, initial, terminalInput || process.stdin);
+  } finally {
+    terminalInput?.destroy();
+  }
+}
+
 export async function runInteractive(
   components: ResolvedComponent[],
   stdin: NodeJS.ReadStream = process.stdin
@@ -1,13 +1,18 @@
 import { color } from "./ui";
-import { expandPath } from "./utils";
-import { join, dirname } from "node:path";
-import { existsSync, symlinkSync, unlinkSync, readlinkSync, lstatSync, writeFileSync, mkdirSync, readFileSync, statSync, renameSync } from "node:fs";
+import { targetPath, displayTarget, sourcePath, samePath } from "./utils";
+import { dirname } from "node:path";
+import { existsSync, symlinkSync, unlinkSync, readlinkSync, lstatSync, writeFileSync, mkdirSync, readFileSync, statSync, renameSync, readdirSync, rmdirSync, copyFileSync } from "node:fs";
 
 export interface RunOptions {
   dryRun: boolean;
   verbose: boolean;
   interactive: boolean;
   report?: boolean;
+  targetPrefix?: string;
+  allowMissingSource?: boolean;
+  elevated?: boolean;
+  noReplace?: boolean;
+  adopt?: boolean;
 }
 
 export interface LinkResult {
@@ -19,6 +24,10 @@ export interface LinkResult {
   dryRun: boolean;
   skipped: boolean;
   backedUp: boolean;
+  blocked?: boolean;
+  adopted?: boolean;
+  createdDirs?: string[];
+  replacedLink?: string;
   reason?: string;
 }
 
@@ -31,18 +40,88 @@ function isSymlink(p: string): boolean {
   }
 }
 
-export function allLinksCorrect(links: Record<string, string[]>, repoDir: string): boolean {
+function ownerOf(p: string): number | null {
+  try {
+    return lstatSync(p).uid;
+  } catch {
+    return null;
+  }
+}
+
+// A previous sudo run can leave a target, or the directory it lives in,
+// owned by root, and the raw EACCES/EPERM message doesn't say why. Name the
+// cause instead.
+export function linkError(
+  e: NodeJS.ErrnoException,
+  dest: string,
+  owner: (p: string) => number | null = ownerOf
+): string {
+  if ((e.code !== "EACCES" && e.code !== "EPERM") || process.getuid?.() === 0) return e.message;
+  if (owner(dest) === 0) {
+    return `target ${dest} is owned by root; re-run with elevated permissions or remove it manually`;
+  }
+  const dir = dirname(dest);
+  if (owner(dir) === 0) {
+    return `directory ${dir} is owned by root, so ${dest} can't be changed; re-run with elevated permissions or fix its ownership`;
+  }
+  return e.message;
+}
+
+// A regular file whose bytes match the source is already what the link would
+// give, so --adopt can swap it for the link without keeping a backup.
+function identicalFile(src: string, dest: string): boolean {
+  try {
+    const a = lstatSync(src);
+    const b = lstatSync(dest);
+    if (!a.isFile() || !b.isFile() || a.size !== b.size) return false;
+    return readFileSync(src).equals(readFileSync(dest));
+  } catch {
+    return false;
+  }
+}
+
+// Creates dir and any missing parents, returning the ones that did not exist
+// before (outermost first) so callers can say what dot made on their behalf.
+function makeParentDirs(dir: string): string[] {
+  const missing: string[] = [];
+  for (let d = dir; !existsSync(d) && dirname(d) !== d; d = dirname(d)) missing.unshift(d);
+  if (missing.length === 0) return [];
+  mkdirSync(dir, { recursive: true });
+  return missing;
+}
+
+function sudo(args: string[]): string | null {
+  if (process.platform === "win32") return "elevated links are not supported on Windows";
+  if (!Bun.which("sudo")) return "sudo not found";
+  // stdin stays attached so sudo can prompt for a password
+  const proc = Bun.spawnSync(["sudo", ...args], { stdin: "inherit", stdout: "inherit", stderr: "pipe" });
+  if (proc.exitCode === 0) return null;
+  return proc.stderr.toString().trim() || `sudo ${args[0]} exited with ${proc.exitCode}`;
+}
+
+function elevatedLink(absSrc: string, dest: string): string | null {
+  if (existsSync(dest) && !isSymlink(dest)) {
+    return "target exists and is not a symlink; elevated links never replace files";
+  }
+  if (!existsSync(dirname(dest))) {
+    const failure = sudo(["mkdir", "-p", dirname(dest)]);
+    if (failure) return failure;
+  }
+  return sudo(["ln", "-sfn", absSrc, dest]);
+}
+
+export function allLinksCorrect(links: Record<string, string[]>, repoDir: string, targetPrefix?: string): boolean {
   if (Object.keys(links).length === 0) return false;
   for (const [src, targets] of Object.entries(links)) {
-    const absSrc = join(repoDir, src);
+    const absSrc = sourcePath(repoDir, src);
     if (!existsSync(absSrc)) return false;
     for (const target of targets) {
-      const dest = expandPath(target);
-      if (!existsSync(dest)) return false;
-      if (!isSymlink(dest)) return false;
       try {
+        const dest = targetPath(target, targetPrefix);
+        if (!existsSync(dest)) return false;
+        if (!isSymlink(dest)) return false;
         const existingTarget = readlinkSync(dest);
-        if (existingTarget !== absSrc) return false;
+        if (!samePath(existingTarget, absSrc)) return false;
       } catch {
         return false;
       }
@@ -51,6 +130,46 @@ export function allLinksCorrect(links: Record<string, string[]>, repoDir: string
   return true;
 }
 
+export type LinkState = "ok" | "missing" | "dangling" | "elsewhere";
+
+export interface ManagedLink {
+  component: string;
+  target: string;
+  source: string;
+  state: LinkState;
+}
+
+export function managedLinks(
+  component: string,
+  links: Record<string, string[]>,
+  repoDir: string,
+  prefix?: string
+): ManagedLink[] {
+  const managed: ManagedLink[] = [];
+  for (const [src, targets] of Object.entries(links)) {
+    const source = sourcePath(repoDir, src);
+    for (const t of targets) {
+      const target = displayTarget(t, prefix);
+      let state: LinkState = "ok";
+      if (!isSymlink(target)) {
+        state = existsSync(target) ? "elsewhere" : "missing";
+      } else if (!samePath(readlinkSync(target), source)) {
+        state = "elsewhere";
+      } else if (!existsSync(source)) {
+        state = "dangling";
+      }
+      managed.push({ component, target, source, state });
+    }
+  }
+  return managed;
+}
+
+// A target that can't be expanded (a ~user this machine doesn't know) fails
+// that one link rather than the whole config.
+function unresolvedTarget(component: string, src: string, target: string, reason: string): LinkResult {
+  return { component, src, dest: target, success: false, failed: true, dryRun: false, skipped: false, backedUp: false, reason };
+}
+
 export function createLinks(
   component: string,
   links: Record<string, string[]>,
@@ -60,10 +179,19 @@ export function createLinks(
   const results: LinkResult[] = [];
 
   for (const [src, targets] of Object.entries(links)) {
-    const absSrc = join(repoDir, src);
+    const absSrc = sourcePath(repoDir, src);
 
     for (const target of targets) {
-      const dest = expandPath(target);
+      let dest: string;
+      try {
+        dest = targetPath(target, options.targetPrefix);
+      } catch (e: any) {
+        if (options.verbose) {
+          process.stderr.write(`  ${color("[error]", "red")} ${component}: ${e.message}\n`);
+        }
+        results.push(unresolvedTarget(component, absSrc, target, e.message));
+        continue;
+      }
       const base: LinkResult = {
         component,
         src: absSrc,
@@ -76,11 +204,20 @@ export function createLinks(
       };
 
       if (options.dryRun) {
-        if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} would link ${src} → ${dest}\n`);
+        const suffix = options.elevated ? " (sudo)" : "";
+        if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} would link ${src} → ${dest}${suffix}\n`);
         results.push({ ...base, success: true, dryRun: true });
         continue;
       }
 
+      if (!existsSync(absSrc) && options.allowMissingSource) {
+        if (options.report || options.verbose) {
+          process.stdout.write(`  ${color("[warn]", "yellow")} ${component}: source not found, skipping ${dest}\n`);
+        }
+        results.push({ ...base, skipped: true, reason: `source not found: ${absSrc}` });
+        continue;
+      }
+
       if (!existsSync(absSrc)) {
         if (options.verbose) {
           process.stdout.write(`  ${color("[warn]", "yellow")} ${component}: source not found: ${absSrc}\n`);
@@ -89,45 +226,121 @@ export function createLinks(
         continue;
       }
 
-      if (existsSync(dest)) {
-        if (isSymlink(dest)) {
-          const existingTarget = readlinkSync(dest);
-          if (existingTarget === absSrc) {
-            if (options.report) process.stdout.write(`    ${color("✓", "green")} linked ${dest}\n`);
-            results.push({ ...base, success: true, skipped: true, reason: "symlink exists and points correctly" });
-            continue;
-          }
-          unlinkSync(dest);
-        } else if (statSync(dest).isDirectory()) {
-          const bak = dest + ".dot.bak";
+      if (/[\\/]$/.test(src) && !statSync(absSrc).isDirectory()) {
+        const reason = `source ${src} ends with "/" but is not a directory`;
+        if (options.verbose) {
+          process.stderr.write(`  ${color("[error]", "red")} ${component}: ${reason}\n`);
+        }
+        results.push({ ...base, failed: true, reason });
+        continue;
+      }
+
+      const present = existsSync(dest) || isSymlink(dest);
+      const adoptable = options.adopt === true && present && identicalFile(absSrc, dest);
+      if (options.noReplace && present && !adoptable && !(isSymlink(dest) && samePath(readlinkSync(dest), absSrc))) {
+        const reason = isSymlink(dest) ? `symlink points to ${readlinkSync(dest)}` : "a real file is in the way";
+        if (options.report || options.verbose) {
+          process.stdout.write(`  ${color("[blocked]", "yellow")} ${component}: ${dest}: ${reason}\n`);
+        }
+        results.push({ ...base, skipped: true, blocked: true, reason });
+        continue;
+      }
+
+      if (options.elevated) {
+        if (isSymlink(dest) && samePath(readlinkSync(dest), absSrc)) {
+          if (options.report) process.stdout.write(`    ${color("✓", "green")} linked ${dest}\n`);
+          results.push({ ...base, success: true, skipped: true, reason: "symlink exists and points correctly" });
+          continue;
+        }
+        const replacedLink = isSymlink(dest) ? readlinkSync(dest) : undefined;
+        const failure = elevatedLink(absSrc, dest);
+        if (failure) {
           if (options.verbose) {
-            process.stdout.write(`  ${color("[backup]", "cyan")} ${dest} → ${bak}\n`);
+            process.stderr.write(`  ${color("[error]", "red")} ${component}: failed to link ${dest}: ${failure}\n`);
           }
-          renameSync(dest, bak);
+          results.push({ ...base, failed: true, reason: failure });
         } else {
-          const bak = dest + ".dot.bak";
-          writeFileSync(bak, readFileSync(dest));
-          if (options.verbose) {
-            process.stdout.write(`  ${color("[backup]", "cyan")} ${dest} → ${bak}\n`);
-          }
-          unlinkSync(dest);
+          if (options.report) process.stdout.write(`    ${color("✓", "green")} linked ${dest} (sudo)\n`);
+          results.push({ ...base, success: true, ...(replacedLink !== undefined ? { replacedLink } : {}) });
         }
+        continue;
       }
 
-      const destDir = dirname(dest);
+      let backedUp = false;
+      let replacedLink: string | undefined;
       try {
-        mkdirSync(destDir, { recursive: true });
-      } catch {}
+        if (adoptable) {
+          unlinkSync(dest);
+          symlinkSync(absSrc, dest);
+          if (options.report || options.verbose) {
+            process.stdout.write(`  ${color("[adopted]", "cyan")} ${component}: ${dest} matched ${src}, now a link\n`);
+          }
+          results.push({ ...base, success: true, adopted: true });
+          continue;
+        }
+        if (existsSync(dest) || isSymlink(dest)) {
+          if (isSymlink(dest)) {
+            const existingTarget = readlinkSync(dest);
+            if (samePath(existingTarget, absSrc)) {
+              if (options.report) process.stdout.write(`    ${color("✓", "green")} linked ${dest}\n`);
+              results.push({ ...base, success: true, skipped: true, reason: "symlink exists and points correctly" });
+              continue;
+            }
+            if (!existsSync(dest) && (options.report || options.verbose)) {
+              process.stdout.write(`  ${color("[relink]", "cyan")} relinking stale symlink from ${existingTarget} to ${absSrc}\n`);
+            }
+            unlinkSync(dest);
+            replacedLink = existingTarget;
+          } else if (statSync(dest).isDirectory() && readdirSync(dest).length > 0) {
+            const reason = "target is a populated directory; link the files inside it instead";
+            if (options.verbose) {
+              process.stderr.write(`  ${color("[error]", "red")} ${component}: ${dest}: ${reason}\n`);
+            }
+            results.push({ ...base, failed: true, reason });
+            continue;
+          } else if (statSync(dest).isDirectory()) {
+            const bak = dest + ".dot.bak";
+            if (options.verbose) {
+              process.stdout.write(`  ${color("[backup]", "cyan")} ${dest} → ${bak}\n`);
+            }
+            renameSync(dest, bak);
+            backedUp = true;
+          } else {
+            const bak = dest + ".dot.bak";
+            writeFileSync(bak, readFileSync(dest));
+            if (options.verbose) {
+              process.stdout.write(`  ${color("[backup]", "cyan")} ${dest} → ${bak}\n`);
+            }
+            unlinkSync(dest);
+            backedUp = true;
+          }
+        }
+
+        let createdDirs: string[] = [];
+        try {
+          createdDirs = makeParentDirs(dirname(dest));
+        } catch {}
+        if (options.verbose) {
+          for (const dir of createdDirs) {
+            process.stdout.write(`  ${color("[mkdir]", "cyan")} created directory ${dir}\n`);
+          }
+        }
 
-      try {
         symlinkSync(absSrc, dest);
         if (options.report) process.stdout.write(`    ${color("✓", "green")} linked ${dest}\n`);
-        results.push({ ...base, success: true });
+        results.push({
+          ...base,
+          success: true,
+          backedUp,
+          ...(createdDirs.length > 0 ? { createdDirs } : {}),
+          ...(replacedLink !== undefined ? { replacedLink } : {}),
+        });
       } catch (e: any) {
+        const reason = linkError(e, dest);
         if (options.verbose) {
-          process.stderr.write(`  ${color("[error]", "red")} ${component}: failed to link ${dest}: ${e.message}\n`);
+          process.stderr.write(`  ${color("[error]", "red")} ${component}: failed to link ${dest}: ${reason}\n`);
         }
-        results.push({ ...base, failed: true, reason: e.message });
+        results.push({ ...base, failed: true, reason });
       }
     }
   }
@@ -135,6 +348,48 @@ export function createLinks(
   return results;
 }
 
+// Links from an elevated component were made with sudo, so they are undone
+// with sudo too.
+export function rollbackLinks(component: string, created: LinkResult[], options: RunOptions): void {
+  const asRoot = (args: string[]) => {
+    const failure = sudo(args);
+    if (failure) throw new Error(failure);
+  };
+  for (const r of created) {
+    try {
+      if (isSymlink(r.dest)) {
+        if (options.elevated) asRoot(["rm", r.dest]);
+        else unlinkSync(r.dest);
+      }
+      const bak = r.dest + ".dot.bak";
+      if (r.backedUp && existsSync(bak)) {
+        renameSync(bak, r.dest);
+        if (options.report) process.stdout.write(`  ${color("[rollback]", "yellow")} restored ${r.dest}\n`);
+      } else if (r.adopted) {
+        // Adopted files were byte-identical to the source, so a copy of it
+        // is the file the user had before.
+        copyFileSync(r.src, r.dest);
+        if (options.report) process.stdout.write(`  ${color("[rollback]", "yellow")} restored ${r.dest}\n`);
+      } else if (r.replacedLink !== undefined) {
+        if (options.elevated) asRoot(["ln", "-sfn", r.replacedLink, r.dest]);
+        else symlinkSync(r.replacedLink, r.dest);
+        if (options.report) process.stdout.write(`  ${color("[rollback]", "yellow")} relinked ${r.dest} → ${r.replacedLink}\n`);
+      } else if (options.report) {
+        process.stdout.write(`  ${color("[rollback]", "yellow")} removed ${r.dest}\n`);
+      }
+      for (const dir of [...(r.createdDirs ?? [])].reverse()) {
+        try {
+          rmdirSync(dir);
+        } catch {
+          break;
+        }
+      }
+    } catch (e: any) {
+      process.stderr.write(`  ${color("[error]", "red")} ${component}: rollback of ${r.dest} failed: ${e.message}\n`);
+    }
+  }
+}
+
 export function removeLinks(
   component: string,
   links: Record<string, string[]>,
@@ -145,7 +400,16 @@ export function removeLinks(
 
   for (const [_src, targets] of Object.entries(links)) {
     for (const target of targets) {
-      const dest = expandPath(target);
+      let dest: string;
+      try {
+        dest = targetPath(target, options.targetPrefix);
+      } catch (e: any) {
+        if (options.verbose) {
+          process.stderr.write(`  ${color("[error]", "red")} ${component}: ${e.message}\n`);
+        }
+        results.push(unresolvedTarget(component, _src, target, e.message));
+        continue;
+      }
       const base: LinkResult = {
         component,
         src: _src,
@@ -157,12 +421,6 @@ export function removeLinks(
         backedUp: false,
       };
 
-      if (options.dryRun) {
-        if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} would unlink ${dest}\n`);
-        results.push({ ...base, success: true, dryRun: true });
-        continue;
-      }
-
       if (!existsSync(dest)) {
         if (options.verbose) {
           process.stdout.write(`  ${color("[skip]", "dim")} ${component}: not found: ${dest}\n`);
@@ -179,15 +437,27 @@ export function removeLinks(
         continue;
       }
 
+      if (options.dryRun) {
+        if (options.report) process.stdout.write(`  ${color("[dry-run]", "yellow")} would remove link ${dest}\n`);
+        results.push({ ...base, success: true, dryRun: true });
+        continue;
+      }
+
       try {
-        unlinkSync(dest);
+        if (options.elevated) {
+          const failure = sudo(["rm", dest]);
+          if (failure) throw new Error(failure);
+        } else {
+          unlinkSync(dest);
+        }
         if (options.report) process.stdout.write(`    ${color("✓", "green")} unlinked ${dest}\n`);
         results.push({ ...base, success: true });
       } catch (e: any) {
+        const reason = linkError(e, dest);
         if (options.verbose) {
-          process.stderr.write(`  ${color("[error]", "red")} ${component}: failed to unlink ${dest}: ${e.message}\n`);
+          process.stderr.write(`  ${color("[error]", "red")} ${component}: failed to unlink ${dest}: ${reason}\n`);
         }
-        results.push({ ...base, failed: true, reason: e.message });
+        results.push({ ...base, failed: true, reason });
       }
     }
   }
@@ -0,0 +1,107 @@
+import { color } from "./ui";
+
+export interface ComponentOutcome {
+  name: string;
+  installed: boolean;
+  linksCreated: number;
+  linksCorrect: number;
+  hooksRun: number;
+  failed: boolean;
+  durationMs: number;
+  command?: string;
+  rolledBack?: boolean;
+  attempts?: number;
+  hookOutput?: { hook: string; output: string }[];
+}
+
+export interface Metrics {
+  total: number;
+  installed: number;
+  linked: number;
+  skipped: number;
+  failed: number;
+  rolledBack: number;
+  linksCreated: number;
+  linksCorrect: number;
+  durationMs: number;
+  slowest: { name: string; durationMs: number } | null;
+  retried: { name: string; attempts: number }[];
+}
+
+export type OutcomeStatus = "failed" | "rolled back" | "installed" | "linked" | "skipped";
+
+export function outcomeStatus(outcome: ComponentOutcome): OutcomeStatus {
+  if (outcome.failed) return outcome.rolledBack ? "rolled back" : "failed";
+  if (outcome.installed) return "installed";
+  if (outcome.linksCreated > 0) return "linked";
+  return "skipped";
+}
+
+export function outcomeChanged(outcome: ComponentOutcome): boolean {
+  const status = outcomeStatus(outcome);
+  return status === "installed" || status === "linked";
+}
+
+export function computeMetrics(outcomes: ComponentOutcome[], startedAt: number, endedAt = Date.now()): Metrics {
+  const metrics: Metrics = {
+    total: outcomes.length,
+    installed: 0,
+    linked: 0,
+    skipped: 0,
+    failed: 0,
+    rolledBack: 0,
+    linksCreated: 0,
+    linksCorrect: 0,
+    durationMs: endedAt - startedAt,
+    slowest: null,
+    retried: [],
+  };
+
+  for (const outcome of outcomes) {
+    const status = outcomeStatus(outcome);
+    if (status === "rolled back") {
+      metrics.failed++;
+      metrics.rolledBack++;
+    } else {
+      metrics[status]++;
+    }
+    metrics.linksCreated += outcome.linksCreated;
+    metrics.linksCorrect += outcome.linksCorrect;
+    if (outcome.attempts && outcome.attempts > 1) {
+      metrics.retried.push({ name: outcome.name, attempts: outcome.attempts });
+    }
+    if (!metrics.slowest || outcome.durationMs > metrics.slowest.durationMs) {
+      metrics.slowest = { name: outcome.name, durationMs: outcome.durationMs };
+    }
+  }
+
+  return metrics;
+}
+
+export function formatDuration(ms: number): string {
+  if (ms < 1000) return `${ms}ms`;
+  return `${(ms / 1000).toFixed(1)}s`;
+}
+
+export function failedSummary(metrics: Metrics): string {
+  return metrics.rolledBack > 0
+    ? `${metrics.failed} failed, ${metrics.rolledBack} of them rolled back`
+    : `${metrics.failed} failed`;
+}
+
+export function printMetrics(metrics: Metrics): void {
+  const slowest = metrics.slowest
+    ? ` (slowest: ${metrics.slowest.name} ${formatDuration(metrics.slowest.durationMs)})`
+    : "";
+  process.stdout.write(`\n  ${color("Metrics", "bold")}\n`);
+  process.stdout.write(
+    `    components  ${metrics.total} (${metrics.installed} installed, ${metrics.linked} linked, ` +
+    `${metrics.skipped} skipped, ${failedSummary(metrics)})\n`
+  );
+  process.stdout.write(`    links       ${metrics.linksCreated} created, ${metrics.linksCorrect} already correct\n`);
+  if (metrics.retried.length > 0) {
+    const retried = metrics.retried.map((r) => `${r.name} (${r.attempts} attempts)`).join(", ");
+    process.stdout.write(`    retries     ${retried}\n`);
+  }
+  process.stdout.write(`    time        ${formatDuration(metrics.durationMs)}${slowest}\n`);
+}
@@ -0,0 +1,161 @@
+import { color } from "./ui";
+import { expandPath } from "./utils";
+import type { Component } from "./config";
+import { join, dirname, basename, resolve } from "node:path";
+import { existsSync, mkdirSync, readFileSync } from "node:fs";
+import { createHash } from "node:crypto";
+
+export interface RepoOptions {
+  ref: string | null;
+  dryRun: boolean;
+  verbose: boolean;
+}
+
+export function repoCacheDir(url: string): string {
+  const base = process.env.XDG_CACHE_HOME || expandPath("~/.cache");
+  const slug = url
+    .replace(/^[a-z]+:\/\//i, "")
+    .replace(/\.git$/, "")
+    .replace(/[^A-Za-z0-9._-]+/g, "-")
+    .replace(/^-+|-+$/g, "");
+  // The slug alone maps host/a/b and host/a-b to the same directory.
+  const hash = createHash("sha256").update(url).digest("hex").slice(0, 8);
+  return join(base, "dot", "repos", `${slug}-${hash}`);
+}
+
+function git(args: string[], cwd?: string): { exitCode: number; stdout: string; stderr: string } {
+  const proc = Bun.spawnSync(["git", ...args], { cwd, stdin: "ignore", stdout: "pipe", stderr: "pipe" });
+  return { exitCode: proc.exitCode, stdout: proc.stdout.toString(), stderr: proc.stderr.toString().trim() };
+}
+
+function gitOrThrow(args: string[], cwd?: string): void {
+  const result = git(args, cwd);
+  if (result.exitCode !== 0) {
+    throw new Error(`git ${args[0]} failed: ${result.stderr || `exit ${result.exitCode}`}`);
+  }
+}
+
+function defaultBranch(dir: string): string {
+  let head = git(["symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD"], dir);
+  if (head.exitCode !== 0) {
+    gitOrThrow(["remote", "set-head", "origin", "--auto"], dir);
+    head = git(["symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD"], dir);
+  }
+  if (head.exitCode !== 0) throw new Error(`can't tell the default branch of ${dir}: ${head.stderr}`);
+  return head.stdout.trim().replace(/^origin\//, "");
+}
+
+export function syncRepo(url: string, options: RepoOptions): string {
+  const dir = repoCacheDir(url);
+  const cloned = existsSync(join(dir, ".git"));
+
+  if (options.dryRun) {
+    if (!cloned) {
+      throw new Error(`${url} has not been cloned yet. Run without --dry-run to clone it.`);
+    }
+    process.stdout.write(`  ${color("[dry-run]", "yellow")} using cached ${url} without pulling\n`);
+    return dir;
+  }
+
+  if (!cloned) {
+    if (options.verbose) {
+      process.stdout.write(`  ${color("[repo]", "blue")} cloning ${url} → ${dir}\n`);
+    }
+    mkdirSync(dirname(dir), { recursive: true });
+    const args = ["clone", "--quiet"];
+    if (options.ref) args.push("--branch", options.ref);
+    gitOrThrow([...args, url, dir]);
+    return dir;
+  }
+
+  if (options.verbose) {
+    process.stdout.write(`  ${color("[repo]", "blue")} updating ${dir}\n`);
+  }
+  gitOrThrow(["fetch", "--quiet", "--tags", "origin"], dir);
+  // Without a ref, go back to the remote's default branch: an earlier
+  // --repo-ref may have left a tag (detached HEAD) or another branch checked out.
+  gitOrThrow(["checkout", "--quiet", options.ref || defaultBranch(dir)], dir);
+  if (git(["symbolic-ref", "--quiet", "HEAD"], dir).exitCode === 0) {
+    gitOrThrow(["pull", "--quiet", "--ff-only"], dir);
+  }
+  return dir;
+}
+
+// Files changed between `base` and the working tree, relative to `cwd` (the
+// directory holding dot.toml) so they compare directly with link sources.
+// Files changed since base plus untracked ones (a new source file has no diff
+// yet), relative to cwd.
+export function changedFiles(base: string, cwd: string = process.cwd()): string[] {
+  const result = git(["diff", "--name-only", "--relative", base], cwd);
+  if (result.exitCode !== 0) {
+    throw new Error(`git diff failed: ${result.stderr || `exit ${result.exitCode}`}`);
+  }
+  const untracked = git(["ls-files", "--others", "--exclude-standard"], cwd);
+  if (untracked.exitCode !== 0) {
+    throw new Error(`git ls-files failed: ${untracked.stderr || `exit ${untracked.exitCode}`}`);
+  }
+  const files = [...result.stdout.split("\n"), ...untracked.stdout.split("\n")];
+  return [...new Set(files.filter((line) => line.length > 0))];
+}
+
+// What changed in the config file since base: the sections (components) that
+// were added or edited, and the top-level settings, which can affect every
+// component. A config that didn't exist at base counts as empty.
+export function changedSections(
+  base: string,
+  configFile: string = "dot.toml",
+  cwd: string = process.cwd()
+): { sections: string[]; settings: string[] } {
+  const parse = (text: string, where: string): Record<string, unknown> => {
+    try {
+      return Bun.TOML.parse(text) as Record<string, unknown>;
+    } catch (e: any) {
+      throw new Error(`Invalid TOML in ${configFile} at ${where}: ${e.message}`);
+    }
+  };
+  const old = git(["show", `${base}:./${configFile}`], cwd);
+  const before = old.exitCode === 0 ? parse(old.stdout, base) : {};
+  const after = parse(readFileSync(join(cwd, configFile), "utf8"), "the working tree");
+  const isSection = (value: unknown) => typeof value === "object" && value !== null && !Array.isArray(value);
+  const differs = (key: string) => JSON.stringify(before[key]) !== JSON.stringify(after[key]);
+
+  const sections = Object.keys(after).filter((key) => isSection(after[key]) && differs(key));
+  const settings = [...new Set([...Object.keys(before), ...Object.keys(after)])]
+    .filter((key) => !isSection(before[key]) && !isSection(after[key]) && differs(key));
+  return { sections, settings };
+}
+
+function sourceFiles(component: Component): string[] {
+  return [
+    ...Object.keys(component.link),
+    ...Object.values(component.defaults),
+    ...Object.values(component.defaultsCurrentHost ?? {}),
+  ].map((src) => src.replace(/^\.\//, "").replace(/\/+$/, ""));
+}
+
+// Components with a link or defaults source that is one of `paths` or a
+// directory containing one of them.
+export function componentsForPaths(components: Component[], paths: string[]): string[] {
+  return components
+    .filter((c) => sourceFiles(c).some((src) => paths.some((p) => p === src || p.startsWith(src + "/"))))
+    .map((c) => c.name);
+}
+
+// For --require-git: the config must live in a git work tree and match what
+// is committed, unless allowDirty is set. Returns why not, or null.
+export function requireGit(configPath: string, allowDirty: boolean): string | null {
+  const absConfig = resolve(configPath);
+  const dir = dirname(absConfig);
+  if (git(["rev-parse", "--is-inside-work-tree"], dir).stdout.trim() !== "true") {
+    return `${dir} is not inside a git repository (--require-git)`;
+  }
+  if (allowDirty) return null;
+  const status = git(["status", "--porcelain", "--", basename(absConfig)], dir);
+  if (status.exitCode !== 0) {
+    return `git status failed: ${status.stderr || `exit ${status.exitCode}`}`;
+  }
+  if (status.stdout.trim() !== "") {
+    return `${basename(absConfig)} has uncommitted changes; commit them or pass --allow-dirty`;
+  }
+  return null;
+}
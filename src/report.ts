@@ -0,0 +1,111 @@
+import { ComponentOutcome, Metrics, outcomeStatus, formatDuration, failedSummary } from "./metrics";
+import { writeFileSync } from "node:fs";
+
+export interface ReportInfo {
+  os: string;
+  date: Date;
+  metrics: Metrics;
+  failures: string[];
+}
+
+const COLUMNS = ["Component", "Status", "Install command", "Attempts", "Links created", "Links correct", "Hooks", "Time"];
+
+function row(outcome: ComponentOutcome): string[] {
+  return [
+    outcome.name,
+    outcomeStatus(outcome),
+    outcome.command || "",
+    outcome.attempts ? String(outcome.attempts) : "",
+    String(outcome.linksCreated),
+    String(outcome.linksCorrect),
+    String(outcome.hooksRun),
+    formatDuration(outcome.durationMs),
+  ];
+}
+
+function summary(metrics: Metrics): string {
+  return `${metrics.total} components (${metrics.installed} installed, ${metrics.linked} linked, ` +
+    `${metrics.skipped} skipped, ${failedSummary(metrics)}) in ${formatDuration(metrics.durationMs)}`;
+}
+
+function hookOutputs(outcomes: ComponentOutcome[]): { title: string; output: string }[] {
+  return outcomes.flatMap((o) =>
+    (o.hookOutput ?? []).map((h) => ({ title: `${o.name} ${h.hook}`, output: h.output.trimEnd() }))
+  );
+}
+
+// A code fence longer than any run of backticks in the output it wraps.
+function fence(output: string): string {
+  const longest = Math.max(0, ...(output.match(/`+/g) ?? []).map((run) => run.length));
+  return "`".repeat(Math.max(3, longest + 1));
+}
+
+function escapeMarkdown(value: string): string {
+  return value.replace(/\|/g, "\\|");
+}
+
+function escapeHtml(value: string): string {
+  return value.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;").replace(/"/g, "&quot;");
+}
+
+export function renderMarkdown(outcomes: ComponentOutcome[], info: ReportInfo): string {
+  const lines = [
+    "# dot run report",
+    "",
+    `- Date: ${info.date.toISOString()}`,
+    `- OS: ${info.os} (${process.arch})`,
+    `- Result: ${summary(info.metrics)}`,
+    "",
+    `| ${COLUMNS.join(" | ")} |`,
+    `|${COLUMNS.map(() => "---").join("|")}|`,
+    ...outcomes.map((o) => `| ${row(o).map((cell, i) => i === 2 && cell ? `\`${escapeMarkdown(cell)}\`` : escapeMarkdown(cell)).join(" | ")} |`),
+  ];
+  if (info.failures.length > 0) {
+    lines.push("", "## Failures", "", ...info.failures.map((f) => `- ${escapeMarkdown(f)}`));
+  }
+  const outputs = hookOutputs(outcomes);
+  if (outputs.length > 0) {
+    lines.push("", "## Hook output");
+    for (const { title, output } of outputs) {
+      lines.push("", `### ${escapeMarkdown(title)}`, "", fence(output), output, fence(output));
+    }
+  }
+  return lines.join("\n") + "\n";
+}
+
+export function renderHtml(outcomes: ComponentOutcome[], info: ReportInfo): string {
+  const cells = (values: string[], tag: string) => values.map((v) => `<${tag}>${escapeHtml(v)}</${tag}>`).join("");
+  const lines = [
+    "<!doctype html>",
+    "<html>",
+    "<head><meta charset=\"utf-8\"><title>dot run report</title></head>",
+    "<body>",
+    "<h1>dot run report</h1>",
+    "<ul>",
+    `<li>Date: ${escapeHtml(info.date.toISOString())}</li>`,
+    `<li>OS: ${escapeHtml(`${info.os} (${process.arch})`)}</li>`,
+    `<li>Result: ${escapeHtml(summary(info.metrics))}</li>`,
+    "</ul>",
+    "<table>",
+    `<tr>${cells(COLUMNS, "th")}</tr>`,
+    ...outcomes.map((o) => `<tr>${cells(row(o), "td")}</tr>`),
+    "</table>",
+  ];
+  if (info.failures.length > 0) {
+    lines.push("<h2>Failures</h2>", "<ul>", ...info.failures.map((f) => `<li>${escapeHtml(f)}</li>`), "</ul>");
+  }
+  const outputs = hookOutputs(outcomes);
+  if (outputs.length > 0) {
+    lines.push("<h2>Hook output</h2>");
+    for (const { title, output } of outputs) {
+      lines.push(`<h3>${escapeHtml(title)}</h3>`, `<pre>${escapeHtml(output)}</pre>`);
+    }
+  }
+  lines.push("</body>", "</html>");
+  return lines.join("\n") + "\n";
+}
+
+export function writeReport(path: string, outcomes: ComponentOutcome[], info: ReportInfo): void {
+  const render = /\.html?$/i.test(path) ? renderHtml : renderMarkdown;
+  writeFileSync(path, render(outcomes, info));
+}
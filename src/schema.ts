@@ -0,0 +1,110 @@
+const command = {
+  oneOf: [
+    { type: "string", description: "Shell command" },
+    { type: "array", items: { type: "string" }, description: "argv, run directly without a shell" },
+  ],
+};
+
+const hook = {
+  oneOf: [
+    { type: "string" },
+    { type: "array", items: { type: "string" }, description: "Commands run in order, stopping at the first failure" },
+  ],
+};
+
+const targets = {
+  oneOf: [
+    { type: "string" },
+    { type: "array", items: { type: "string" } },
+  ],
+};
+
+const osTargets = {
+  type: "object",
+  properties: { mac: targets, darwin: targets, linux: targets, windows: targets },
+  additionalProperties: false,
+};
+
+// Keep in sync with COMPONENT_KEYS in config.ts; tests/schema.test.ts checks both lists match.
+export const componentSchema = {
+  type: "object",
+  properties: {
+    install: {
+      type: "object",
+      description: "Install command per package manager; the first one on PATH wins, `any` is the fallback",
+      additionalProperties: command,
+    },
+    uninstall: {
+      type: "object",
+      description: "Uninstall command per package manager, picked like install",
+      additionalProperties: { type: "string" },
+    },
+    link: {
+      type: "object",
+      description: "Repo path → target path(s), or per-OS targets",
+      additionalProperties: { oneOf: [...targets.oneOf, osTargets] },
+    },
+    dotfiles: {
+      type: "array",
+      items: { type: "string" },
+      description: "Repo paths linked to ~/.<basename>, e.g. git/gitconfig → ~/.gitconfig",
+    },
+    postinstall: { ...hook, description: "Run after install" },
+    postlink: { ...hook, description: "Run after link" },
+    preuninstall: { ...hook, description: "Run before uninstall or purge" },
+    defaults: {
+      type: "object",
+      description: "macOS defaults domain → plist file",
+      additionalProperties: { type: "string" },
+    },
+    defaults_currenthost: {
+      type: "object",
+      description: "Like defaults, but for per-host (-currentHost) domains",
+      additionalProperties: { type: "string" },
+    },
+    defaults_set: {
+      type: "object",
+      description: "\"<domain> <key>\" → value written with defaults write",
+      additionalProperties: { type: ["string", "number", "boolean"] },
+    },
+    defaults_mode: { enum: ["replace", "merge"] },
+    defaults_format: { enum: ["auto", "xml"] },
+    os: { type: "array", items: { enum: ["mac", "linux", "windows"] } },
+    check: { type: "string", description: "Binary name or shell command that detects an existing install" },
+    description: { type: "string" },
+    shell: { type: "string", description: "Shell for install, uninstall, and hooks" },
+    if_file_exists: { type: "string" },
+    unless_file_exists: { type: "string" },
+    allow_missing_source: { type: "boolean" },
+    rollback: { type: "boolean" },
+    success_if: { type: "string", format: "regex" },
+    fail_if: { type: "string", format: "regex" },
+    elevated: { type: "boolean", description: "Create links with sudo" },
+    retries: { type: "integer", minimum: 0 },
+    retry_backoff: { enum: ["fixed", "exponential"] },
+    retry_delay: { type: "number", minimum: 0, description: "Seconds between retries; the base delay for exponential backoff" },
+    run_first: { type: "boolean", description: "Run before every other component" },
+    run_last: { type: "boolean", description: "Run after every other component" },
+  },
+  additionalProperties: false,
+};
+
+export function configSchema(): Record<string, unknown> {
+  return {
+    $schema: "https://json-schema.org/draft-07/schema#",
+    title: "dot.toml",
+    type: "object",
+    properties: {
+      min_version: { type: "string", pattern: "^v?\\d+(\\.\\d+)*$" },
+      defaults_format: { enum: ["auto", "xml"] },
+      postrun: { ...hook, description: "Run once after a run that changed something" },
+      postrun_always: { type: "boolean" },
+      disabled_managers: {
+        type: "array",
+        items: { type: "string" },
+        description: "Package managers dot never picks, even when they are on PATH",
+      },
+    },
+    additionalProperties: componentSchema,
+  };
+}
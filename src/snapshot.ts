@@ -0,0 +1,58 @@
+import { readFileSync, writeFileSync } from "node:fs";
+import { extname } from "node:path";
+import type { ResolvedComponent } from "./config";
+
+// Components that are set up on this machine: installed according to their
+// check, or with every link in place.
+export function snapshotComponents(resolved: ResolvedComponent[]): string[] {
+  return resolved.filter((c) => c.isInstalled || c.allLinksDone).map((c) => c.name);
+}
+
+export function formatSnapshot(names: string[], file: string): string {
+  if (extname(file) === ".json") {
+    return JSON.stringify({ components: names }, null, 2) + "\n";
+  }
+  const items = names.map((n) => `  - ${n}\n`).join("");
+  return `# written by dot --snapshot; apply with dot --apply ${file}\ncomponents:\n${items}`;
+}
+
+// Reads back what formatSnapshot wrote: JSON for .json files, otherwise a
+// YAML `components:` list. Only that list is understood, not YAML in general.
+export function parseSnapshot(text: string, file: string): string[] {
+  if (extname(file) === ".json") {
+    const parsed = JSON.parse(text);
+    if (!Array.isArray(parsed?.components) || !parsed.components.every((n: unknown) => typeof n === "string")) {
+      throw new Error(`${file}: expected a "components" list of names`);
+    }
+    return parsed.components;
+  }
+
+  const names: string[] = [];
+  let inList = false;
+  for (const raw of text.split("\n")) {
+    const line = raw.replace(/\s+#.*$/, "").trimEnd();
+    if (line.trim() === "" || line.trimStart().startsWith("#")) continue;
+    if (/^components:\s*(\[\s*\])?$/.test(line)) {
+      inList = true;
+      continue;
+    }
+    const item = line.match(/^\s+-\s+["']?([^"']+?)["']?$/);
+    if (inList && item) {
+      names.push(item[1]);
+    } else {
+      throw new Error(`${file}: unexpected line "${raw.trim()}"; expected a "components:" list`);
+    }
+  }
+  if (!inList) throw new Error(`${file}: missing "components:" list`);
+  return names;
+}
+
+export function writeSnapshot(file: string, resolved: ResolvedComponent[]): string[] {
+  const names = snapshotComponents(resolved);
+  writeFileSync(file, formatSnapshot(names, file));
+  return names;
+}
+
+export function readSnapshot(file: string): string[] {
+  return parseSnapshot(readFileSync(file, "utf-8"), file);
+}
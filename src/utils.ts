@@ -1,3 +1,7 @@
+import { join, win32 } from "node:path";
+import { homedir, userInfo } from "node:os";
+import { readFileSync, statSync } from "node:fs";
+
 export function detectOS(): string {
   const platform = process.platform;
   if (platform === "darwin") return "mac";
@@ -6,12 +10,92 @@ export function detectOS(): string {
   return "linux";
 }
 
-export function expandPath(p: string): string {
-  const home = process.env.HOME;
-  if (!home) return p;
-  if (p === "~") return home;
-  if (p.startsWith("~/")) return home + p.slice(1);
-  return p;
+function userHome(user: string): string | null {
+  try {
+    if (user === userInfo().username) return homedir();
+  } catch {}
+  if (process.platform === "darwin") {
+    const proc = Bun.spawnSync(["dscl", ".", "-read", `/Users/${user}`, "NFSHomeDirectory"], { stdout: "pipe", stderr: "ignore" });
+    const match = proc.stdout.toString().match(/NFSHomeDirectory:\s*(\S.*)/);
+    return proc.exitCode === 0 && match ? match[1].trim() : null;
+  }
+  // getent also sees users from LDAP, sssd and other NSS sources.
+  if (Bun.which("getent")) {
+    const proc = Bun.spawnSync(["getent", "passwd", user], { stdout: "pipe", stderr: "ignore" });
+    const fields = proc.stdout.toString().trim().split(":");
+    return proc.exitCode === 0 && fields.length >= 6 ? fields[5] : null;
+  }
+  try {
+    for (const line of readFileSync("/etc/passwd", "utf8").split("\n")) {
+      const fields = line.split(":");
+      if (fields[0] === user && fields.length >= 6) return fields[5];
+    }
+  } catch {}
+  return null;
+}
+
+// Windows environment variable names are case-insensitive.
+function windowsEnv(env: Record<string, string | undefined>, name: string): string | undefined {
+  const key = Object.keys(env).find((k) => k.toUpperCase() === name.toUpperCase());
+  return key === undefined ? undefined : env[key];
+}
+
+// On Windows: %VAR% from the environment (unknown ones stay as written, like
+// cmd does), ~ for the user profile, and / or \ as separators.
+function expandWindowsPath(p: string, env: Record<string, string | undefined>): string {
+  if (p === "") return p;
+  let out = p.replace(/%([^%\s]+)%/g, (match, name) => windowsEnv(env, name) ?? match);
+  if (out === "~" || /^~[\\/]/.test(out)) {
+    const home = windowsEnv(env, "USERPROFILE") ?? windowsEnv(env, "HOME");
+    if (home) out = home + out.slice(1);
+  }
+  return win32.normalize(out);
+}
+
+export function expandPath(
+  p: string,
+  platform: string = process.platform,
+  env: Record<string, string | undefined> = process.env
+): string {
+  if (platform === "win32") return expandWindowsPath(p, env);
+  if (!p.startsWith("~")) return p;
+  if (p === "~" || p.startsWith("~/")) {
+    const home = env.HOME;
+    return home ? home + p.slice(1) : p;
+  }
+  const slash = p.indexOf("/");
+  const user = slash === -1 ? p.slice(1) : p.slice(1, slash);
+  const dir = userHome(user);
+  if (!dir) throw new Error(`unknown user in path: ~${user}`);
+  return slash === -1 ? dir : dir + p.slice(slash);
+}
+
+// A bare drive root keeps its slash: "C:" alone means the drive's current
+// directory, not its root.
+function trimTrailingSlash(p: string): string {
+  if (/^[A-Za-z]:[\\/]+$/.test(p)) return p.slice(0, 3);
+  return p.length > 1 ? p.replace(/(.)[\\/]+$/, "$1") : p;
+}
+
+// A trailing slash only marks a directory, so "~/.config/nvim/" names the same
+// link as "~/.config/nvim".
+export function targetPath(target: string, prefix?: string): string {
+  const expanded = trimTrailingSlash(expandPath(target));
+  return prefix ? join(prefix, expanded) : expanded;
+}
+
+// For listings and plans: the target as written when it names a user this
+// machine doesn't know. Linking it reports the error instead.
+export function displayTarget(target: string, prefix?: string): string {
+  try {
+    return targetPath(target, prefix);
+  } catch {
+    return target;
+  }
+}
+
+export function sourcePath(repoDir: string, src: string): string {
+  return trimTrailingSlash(join(repoDir, src));
 }
 
 export function binaryExists(name: string): boolean {
@@ -21,3 +105,110 @@ export function binaryExists(name: string): boolean {
 export function isTTY(): boolean {
   return process.stdin.isTTY ?? false;
 }
+
+export function shellArgv(command: string, shell?: string): string[] {
+  if (process.platform === "win32") {
+    return [process.env.ComSpec || "cmd.exe", "/d", "/s", "/c", command];
+  }
+  return [shell || Bun.which("bash") || "/bin/sh", "-c", command];
+}
+
+export async function runArgv(
+  argv: string[],
+  stdin: "inherit" | "ignore",
+  env?: Record<string, string>
+): Promise<{ exitCode: number; stdout: Buffer; stderr: Buffer }> {
+  if (!Bun.which(argv[0])) {
+    return { exitCode: 127, stdout: Buffer.alloc(0), stderr: Buffer.from(`command not found: ${argv[0]}`) };
+  }
+  const child = Bun.spawn(argv, {
+    stdin,
+    env: env ? { ...process.env, ...env } : undefined,
+    stdout: "pipe",
+    stderr: "pipe",
+  });
+  const [exitCode, stdout, stderr] = await Promise.all([
+    child.exited,
+    new Response(child.stdout).arrayBuffer(),
+    new Response(child.stderr).arrayBuffer(),
+  ]);
+  return { exitCode, stdout: Buffer.from(stdout), stderr: Buffer.from(stderr) };
+}
+
+export async function runShell(
+  command: string,
+  shell: string | undefined,
+  stdin: "inherit" | "ignore",
+  env?: Record<string, string>
+): Promise<{ exitCode: number; stdout: Buffer; stderr: Buffer }> {
+  return runArgv(shellArgv(command, shell), stdin, env);
+}
+
+// Expands $VAR and ${VAR} the way the shell would, for previews only. Command
+// substitution ($(...), backticks) and anything in single quotes stay literal,
+// so a preview never runs anything.
+export function expandEnv(command: string, env: Record<string, string | undefined> = process.env): string {
+  let out = "";
+  let single = false;
+  let double = false;
+  for (let i = 0; i < command.length; i++) {
+    const ch = command[i];
+    if (ch === "'" && !double) {
+      single = !single;
+    } else if (ch === '"' && !single) {
+      double = !double;
+    } else if (ch === "\\" && !single && i + 1 < command.length) {
+      out += ch + command[++i];
+      continue;
+    } else if (!single && (ch === "`" || command.startsWith("$(", i))) {
+      const end = substitutionEnd(command, i);
+      out += command.slice(i, end);
+      i = end - 1;
+      continue;
+    } else if (ch === "$" && !single) {
+      const rest = command.slice(i + 1);
+      const match = rest.match(/^\{([A-Za-z_]\w*)\}/) || rest.match(/^([A-Za-z_]\w*)/);
+      if (match) {
+        out += env[match[1]] ?? "";
+        i += match[0].length;
+        continue;
+      }
+    }
+    out += ch;
+  }
+  return out;
+}
+
+function substitutionEnd(command: string, start: number): number {
+  if (command[start] === "`") {
+    const close = command.indexOf("`", start + 1);
+    return close === -1 ? command.length : close + 1;
+  }
+  let depth = 0;
+  for (let i = start + 1; i < command.length; i++) {
+    if (command[i] === "(") depth++;
+    else if (command[i] === ")" && --depth === 0) return i + 1;
+  }
+  return command.length;
+}
+
+export function commandText(command: string | string[]): string {
+  if (!Array.isArray(command)) return command;
+  return command.map((arg) => /[\s'"$`\\;&|<>()*?]/.test(arg) ? JSON.stringify(arg) : arg).join(" ");
+}
+
+// Paths that differ only in case name the same file on a case-insensitive
+// filesystem (the macOS and Windows default, though either can be formatted
+// case-sensitive, and one machine can mount both). Ask the filesystem that
+// holds them whether they are one file instead of guessing from the platform.
+export function samePath(a: string, b: string): boolean {
+  if (a === b) return true;
+  if (a.toLowerCase() !== b.toLowerCase()) return false;
+  try {
+    const sa = statSync(a);
+    const sb = statSync(b);
+    return sa.dev === sb.dev && sa.ino === sb.ino;
+  } catch {
+    return false;
+  }
+}
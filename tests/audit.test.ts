@@ -0,0 +1,80 @@
+import { describe, test, expect, beforeEach, afterEach } from "bun:test";
+import { appendAudit, parseSize, rotateIfNeeded, AuditRecord } from "../src/audit";
+import { mkdtempSync, rmSync, writeFileSync, readFileSync, readdirSync, existsSync } from "node:fs";
+import { tmpdir } from "node:os";
+import { join } from "node:path";
+
+let tmp: string;
+
+beforeEach(() => {
+  tmp = mkdtempSync(join(tmpdir(), "dot-audit-"));
+});
+
+afterEach(() => {
+  rmSync(tmp, { recursive: true, force: true });
+});
+
+const record: AuditRecord = {
+  hostname: "laptop",
+  timestamp: "2026-10-16T12:00:00.000Z",
+  components: ["zsh"],
+  changed: 1,
+  failed: 0,
+  version: "dev",
+};
+
+describe("parseSize", () => {
+  test("understands bytes and binary units", () => {
+    expect(parseSize("1048576")).toBe(1048576);
+    expect(parseSize("512K")).toBe(512 * 1024);
+    expect(parseSize("10MB")).toBe(10 * 1024 * 1024);
+    expect(parseSize("ten")).toBeNull();
+    expect(parseSize("10XB")).toBeNull();
+  });
+});
+
+describe("rotateIfNeeded", () => {
+  test("gzips a file past the threshold and starts fresh", () => {
+    const file = join(tmp, "dot.jsonl");
+    const content = "x".repeat(200) + "\n";
+    writeFileSync(file, content);
+
+    const rotated = rotateIfNeeded(file, { maxBytes: 100, keep: 5 }, new Date("2026-10-16T12:00:00.000Z"));
+
+    expect(rotated).toBe(join(tmp, "dot.jsonl.20261016T120000000Z.gz"));
+    expect(existsSync(file)).toBe(false);
+    expect(Buffer.from(Bun.gunzipSync(readFileSync(rotated!))).toString()).toBe(content);
+  });
+
+  test("leaves a file under the threshold alone", () => {
+    const file = join(tmp, "dot.jsonl");
+    writeFileSync(file, "short\n");
+    expect(rotateIfNeeded(file, { maxBytes: 100, keep: 5 })).toBeNull();
+    expect(readFileSync(file, "utf-8")).toBe("short\n");
+  });
+
+  test("keeps only the newest rotated files", () => {
+    const file = join(tmp, "dot.jsonl");
+    for (const day of ["14", "15", "16"]) {
+      writeFileSync(file, "x".repeat(200));
+      rotateIfNeeded(file, { maxBytes: 100, keep: 2 }, new Date(`2026-10-${day}T12:00:00.000Z`));
+    }
+    expect(readdirSync(tmp).sort()).toEqual([
+      "dot.jsonl.20261015T120000000Z.gz",
+      "dot.jsonl.20261016T120000000Z.gz",
+    ]);
+  });
+});
+
+describe("appendAudit", () => {
+  test("rotates before appending once the file is too big", () => {
+    const file = join(tmp, "dot.jsonl");
+    appendAudit(file, record, { maxBytes: 150, keep: 5 });
+    appendAudit(file, record, { maxBytes: 150, keep: 5 });
+    expect(readFileSync(file, "utf-8").trim().split("\n")).toHaveLength(2);
+
+    appendAudit(file, record, { maxBytes: 150, keep: 5 });
+    expect(readFileSync(file, "utf-8").trim().split("\n")).toHaveLength(1);
+    expect(readdirSync(tmp).filter((f) => f.endsWith(".gz"))).toHaveLength(1);
+  });
+});
@@ -0,0 +1,87 @@
+import { describe, test, expect } from "bun:test";
+import { splitInstallCommand, planBatches, batchCommand, runBatches } from "../src/batch";
+import { ResolvedComponent } from "../src/config";
+
+function makeComponent(name: string, installCommand: string, overrides: Partial<ResolvedComponent> = {}): ResolvedComponent {
+  return {
+    name,
+    install: { brew: installCommand },
+    uninstall: {},
+    link: {},
+    defaults: {},
+    defaultsSet: {},
+    availableManager: "brew",
+    installCommand,
+    uninstallCommand: null,
+    hasDefaults: false,
+    hasLinks: false,
+    hasInstall: true,
+    allLinksDone: false,
+    isInstalled: false,
+    skipReason: null,
+    ...overrides,
+  };
+}
+
+describe("splitInstallCommand", () => {
+  test("keeps flags in the shared prefix", () => {
+    expect(splitInstallCommand("brew install --cask zed")).toEqual({ prefix: "brew install --cask", packages: ["zed"] });
+    expect(splitInstallCommand("sudo apt install -y ripgrep fd-find")).toEqual({
+      prefix: "sudo apt install -y",
+      packages: ["ripgrep", "fd-find"],
+    });
+  });
+
+  test("leaves shell pipelines and non-install commands alone", () => {
+    expect(splitInstallCommand("curl https://mise.run | sh")).toBeNull();
+    expect(splitInstallCommand("brew tap foo/bar")).toBeNull();
+    expect(splitInstallCommand("brew install")).toBeNull();
+  });
+});
+
+describe("planBatches", () => {
+  test("the batched command contains every package", () => {
+    const batches = planBatches([
+      makeComponent("btop", "brew install btop"),
+      makeComponent("ripgrep", "brew install ripgrep"),
+      makeComponent("fd", "brew install fd"),
+      makeComponent("zed", "brew install --cask zed"),
+    ]);
+
+    expect(batches).toHaveLength(1);
+    expect(batches[0].components).toEqual(["btop", "ripgrep", "fd"]);
+    expect(batchCommand(batches[0])).toBe("brew install btop ripgrep fd");
+  });
+
+  test("skips components that are installed, gated, or need their own output checks", () => {
+    const batches = planBatches([
+      makeComponent("btop", "brew install btop"),
+      makeComponent("ripgrep", "brew install ripgrep", { isInstalled: true }),
+      makeComponent("fd", "brew install fd", { skipReason: "~/.fd does not exist" }),
+      makeComponent("jq", "brew install jq", { failIf: "Error" }),
+    ]);
+
+    expect(batches).toHaveLength(0);
+  });
+});
+
+describe("runBatches", () => {
+  test("a failed batch installs nothing so components fall back to one by one", async () => {
+    const batches = planBatches([
+      makeComponent("a", "false install a"),
+      makeComponent("b", "false install b"),
+    ]);
+    const installed = await runBatches(batches, { dryRun: false, verbose: false, interactive: false });
+    expect(installed.size).toBe(0);
+  });
+
+  test("a successful batch attributes its command to every component", async () => {
+    const batches = planBatches([
+      makeComponent("a", "true install a"),
+      makeComponent("b", "true install b"),
+    ]);
+    const installed = await runBatches(batches, { dryRun: false, verbose: false, interactive: false });
+    expect(installed.get("a")).toBe("true install a b");
+    expect(installed.get("b")).toBe("true install a b");
+  });
+});
@@ -1,5 +1,5 @@
 import { describe, test, expect } from "bun:test";
-import { parseArgs } from "../src/cli";
+import { parseArgs, expandSubcommand } from "../src/cli";
 
 describe("parseArgs", () => {
   test("no args → interactive mode", () => {
@@ -58,6 +58,16 @@ describe("parseArgs", () => {
     expect(result.uninstall).toEqual(["zsh"]);
   });
 
+  test("--purge zsh → purge", () => {
+    const result = parseArgs(["dot", "--purge", "zsh"]);
+    expect(result.mode).toBe("direct");
+    expect(result.purge).toEqual(["zsh"]);
+  });
+
+  test("--purge without value throws", () => {
+    expect(() => parseArgs(["dot", "--purge"])).toThrow();
+  });
+
   test("-l git → link", () => {
     const result = parseArgs(["dot", "-l", "git"]);
     expect(result.link).toEqual(["git"]);
@@ -179,6 +189,84 @@ describe("parseArgs", () => {
     expect(result.interactiveAction).toBe("postinstall");
   });
 
+  test("--repo and --repo-ref take values", () => {
+    const result = parseArgs(["dot", "--repo", "https://example.com/dots.git", "--repo-ref", "main", "-i", "zsh"]);
+    expect(result.repo).toBe("https://example.com/dots.git");
+    expect(result.repoRef).toBe("main");
+    expect(result.install).toEqual(["zsh"]);
+  });
+
+  test("--repo without value throws", () => {
+    expect(() => parseArgs(["dot", "--repo"])).toThrow();
+  });
+
+  test("--doctor and doctor verb → direct mode", () => {
+    expect(parseArgs(["dot", "--doctor"]).doctor).toBe(true);
+    const result = parseArgs(["dot", "doctor"]);
+    expect(result.doctor).toBe(true);
+    expect(result.mode).toBe("direct");
+  });
+
+  test("flags that only shape a direct run are rejected for the checklist", () => {
+    for (const flag of ["--fail-fast", "--batch-installs", "--compact", "--rollback-on-failure"]) {
+      expect(() => parseArgs(["dot", flag])).toThrow(`Flag ${flag} only applies to non-interactive runs`);
+      expect(parseArgs(["dot", "-i", "zsh", flag]).mode).toBe("direct");
+    }
+  });
+
+  test("--report takes a file path", () => {
+    const result = parseArgs(["dot", "-i", "zsh", "--report", "run.md"]);
+    expect(result.reportFile).toBe("run.md");
+    expect(() => parseArgs(["dot", "-i", "zsh", "--report"])).toThrow();
+    expect(() => parseArgs(["dot", "--report", "run.md"])).toThrow("only reports non-interactive runs");
+  });
+
+  test("--audit-file takes a file path", () => {
+    expect(parseArgs(["dot", "-i", "zsh", "--audit-file", "/var/log/dot.jsonl"]).auditFile).toBe("/var/log/dot.jsonl");
+    expect(() => parseArgs(["dot", "-i", "zsh", "--audit-file"])).toThrow();
+    expect(() => parseArgs(["dot", "--audit-file", "/var/log/dot.jsonl"])).toThrow("only records non-interactive runs");
+  });
+
+  test("--log-max-size and --log-keep configure audit rotation", () => {
+    const result = parseArgs(["dot", "-i", "zsh", "--audit-file", "a.jsonl", "--log-max-size", "10MB", "--log-keep", "3"]);
+    expect(result.logMaxSize).toBe(10 * 1024 * 1024);
+    expect(result.logKeep).toBe(3);
+    expect(parseArgs(["dot", "-i", "zsh"]).logKeep).toBe(5);
+    expect(() => parseArgs(["dot", "-i", "zsh", "--log-max-size", "big"])).toThrow("expects a size");
+  });
+
+  test("--fail-fast is a modifier", () => {
+    const result = parseArgs(["dot", "--fail-fast", "-i", "zsh"]);
+    expect(result.failFast).toBe(true);
+    expect(result.install).toEqual(["zsh"]);
+  });
+
+  test("--simulate-os sets the OS and forces dry-run", () => {
+    const result = parseArgs(["dot", "--simulate-os", "linux", "-i", "zsh"]);
+    expect(result.simulateOS).toBe("linux");
+    expect(result.dryRun).toBe(true);
+    expect(() => parseArgs(["dot", "--simulate-os", "beos"])).toThrow();
+  });
+
+  test("--rollback-on-failure is a modifier", () => {
+    expect(parseArgs(["dot", "-i", "zsh", "--rollback-on-failure"]).rollbackOnFailure).toBe(true);
+  });
+
+  test("--gc takes an optional --gc-older-than age", () => {
+    expect(parseArgs(["dot", "--gc"]).gcOlderThan).toBe("30d");
+    const result = parseArgs(["dot", "gc", "--gc-older-than", "7d"]);
+    expect(result.gc).toBe(true);
+    expect(result.mode).toBe("direct");
+    expect(result.gcOlderThan).toBe("7d");
+    expect(() => parseArgs(["dot", "--gc", "--gc-older-than", "soon"])).toThrow();
+  });
+
+  test("--changed-exit is a modifier", () => {
+    const result = parseArgs(["dot", "-i", "zsh", "--changed-exit"]);
+    expect(result.changedExit).toBe(true);
+    expect(result.mode).toBe("direct");
+  });
+
   test("unknown flag throws", () => {
     expect(() => parseArgs(["dot", "--unknown-flag"])).toThrow();
   });
@@ -187,3 +275,122 @@ describe("parseArgs", () => {
     expect(() => parseArgs(["dot", "-x"])).toThrow();
   });
 });
+
+describe("subcommands", () => {
+  test("install verb takes every following name", () => {
+    const result = parseArgs(["dot", "install", "zsh", "nvim", "-v"]);
+    expect(result.mode).toBe("direct");
+    expect(result.install).toEqual(["zsh", "nvim"]);
+    expect(result.verbose).toBe(true);
+  });
+
+  test("uninstall verb → uninstall", () => {
+    const result = parseArgs(["dot", "uninstall", "zsh"]);
+    expect(result.uninstall).toEqual(["zsh"]);
+  });
+
+  test("uninstall verb without names goes interactive", () => {
+    const result = parseArgs(["dot", "uninstall"]);
+    expect(result.mode).toBe("interactive");
+    expect(result.interactiveAction).toBe("uninstall");
+  });
+
+  test("--json needs a listing action", () => {
+    expect(parseArgs(["dot", "--list", "--json"]).json).toBe(true);
+    expect(parseArgs(["dot", "--list-links", "--json"]).json).toBe(true);
+    expect(() => parseArgs(["dot", "--json"])).toThrow("only applies to --list and --list-links");
+    expect(() => parseArgs(["dot", "-i", "zsh", "--json"])).toThrow("only applies to --list and --list-links");
+  });
+
+  test("list verb → list mode", () => {
+    const result = parseArgs(["dot", "list"]);
+    expect(result.mode).toBe("direct");
+    expect(result.list).toBe(true);
+  });
+
+  test("--expand-dry-run implies --dry-run", () => {
+    const result = parseArgs(["dot", "-i", "zsh", "--expand-dry-run"]);
+    expect(result.expandDryRun).toBe(true);
+    expect(result.dryRun).toBe(true);
+  });
+
+  test("--max-retries parses a count and rejects anything else", () => {
+    expect(parseArgs(["dot", "-i", "zsh", "--max-retries", "3"]).maxRetries).toBe(3);
+    expect(parseArgs(["dot", "-i", "zsh"]).maxRetries).toBeNull();
+    expect(() => parseArgs(["dot", "-i", "zsh", "--max-retries", "two"])).toThrow("non-negative integer");
+  });
+
+  test("--snapshot and --apply take a file and run in direct mode", () => {
+    expect(parseArgs(["dot", "--snapshot", "components.yaml"])).toMatchObject({ mode: "direct", snapshotFile: "components.yaml" });
+    expect(parseArgs(["dot", "--apply", "components.yaml"])).toMatchObject({ mode: "direct", applyFile: "components.yaml" });
+    expect(() => parseArgs(["dot", "--apply"])).toThrow("requires a value");
+  });
+
+  test("--disable-manager is repeatable", () => {
+    const result = parseArgs(["dot", "-i", "btop", "--disable-manager", "snap", "--disable-manager", "flatpak"]);
+    expect(result.disabledManagers).toEqual(["snap", "flatpak"]);
+  });
+
+  test("--components-from-git-diff takes a base ref and runs in direct mode", () => {
+    const result = parseArgs(["dot", "--components-from-git-diff", "origin/main"]);
+    expect(result.mode).toBe("direct");
+    expect(result.gitDiffBase).toBe("origin/main");
+  });
+
+  test("--require-git and --allow-dirty are modifiers", () => {
+    const result = parseArgs(["dot", "-i", "zsh", "--require-git", "--allow-dirty"]);
+    expect(result.requireGit).toBe(true);
+    expect(result.allowDirty).toBe(true);
+    expect(result.install).toEqual(["zsh"]);
+  });
+
+  test("--adopt is a modifier", () => {
+    const result = parseArgs(["dot", "-l", "vim", "--adopt"]);
+    expect(result.adopt).toBe(true);
+    expect(result.link).toEqual(["vim"]);
+  });
+
+  test("-y and --yes skip the uninstall confirmation", () => {
+    expect(parseArgs(["dot", "-u", "zsh", "-y"]).yes).toBe(true);
+    expect(parseArgs(["dot", "--purge", "zsh", "--yes"]).yes).toBe(true);
+    expect(parseArgs(["dot", "-u", "zsh"]).yes).toBe(false);
+  });
+
+  test("list-links verb collects component filters and --broken", () => {
+    const result = parseArgs(["dot", "list-links", "zsh", "git", "--broken"]);
+    expect(result.mode).toBe("direct");
+    expect(result.listLinks).toBe(true);
+    expect(result.linkFilter).toEqual(["zsh", "git"]);
+    expect(result.broken).toBe(true);
+  });
+
+  test("defaults export verb → exportDefaults", () => {
+    const result = parseArgs(["dot", "defaults", "export", "--dry-run"]);
+    expect(result.exportDefaults).toBe(true);
+    expect(result.dryRun).toBe(true);
+  });
+
+  test("defaults import verb → importDefaults", () => {
+    const result = parseArgs(["dot", "defaults", "import"]);
+    expect(result.importDefaults).toBe(true);
+  });
+
+  test("defaults verb without a subcommand throws", () => {
+    expect(() => parseArgs(["dot", "defaults"])).toThrow();
+  });
+
+  test("help and version verbs → meta", () => {
+    expect(parseArgs(["dot", "help"]).meta).toBe("help");
+    expect(parseArgs(["dot", "version"]).meta).toBe("version");
+  });
+
+  test("verbs only dispatch as the first token", () => {
+    expect(expandSubcommand(["dot", "-v", "install"])).toEqual(["dot", "-v", "install"]);
+  });
+
+  test("unknown first token falls back to flag parsing", () => {
+    const argv = ["dot", "nvim", "-i", "zsh"];
+    expect(expandSubcommand(argv)).toEqual(argv);
+    expect(parseArgs(argv).install).toEqual(["zsh"]);
+  });
+});
@@ -0,0 +1,84 @@
+import { describe, test, expect } from "bun:test";
+import { CompactProgress, interruptOnWrite } from "../src/compact";
+
+function makeWriter(isTTY: boolean) {
+  const chunks: string[] = [];
+  return {
+    chunks,
+    isTTY,
+    write(chunk: string) {
+      chunks.push(chunk);
+      return true;
+    },
+    text() {
+      return chunks.join("").replace(/\x1B\[[0-?]*[ -/]*[@-~]/g, "");
+    },
+  };
+}
+
+describe("CompactProgress", () => {
+  test("updates one line in a TTY", () => {
+    const out = makeWriter(true);
+    const progress = new CompactProgress(2, out);
+
+    progress.start("zsh");
+    progress.finish("zsh", "ok");
+    progress.start("nvim");
+    progress.finish("nvim", "failed");
+    progress.end();
+
+    expect(out.chunks.filter((c) => c.includes("\n"))).toHaveLength(1);
+    expect(out.text()).toContain("[0/2] zsh …");
+    expect(out.text()).toContain("[1/2] zsh ✓  nvim …");
+    expect(out.text()).toContain("[2/2] zsh ✓  nvim ✗");
+  });
+
+  test("keeps only the most recent results on the line", () => {
+    const out = makeWriter(true);
+    const progress = new CompactProgress(4, out);
+    for (const name of ["a", "b", "c", "d"]) progress.finish(name, "ok");
+
+    expect(out.chunks[out.chunks.length - 1]).not.toContain("a");
+    expect(out.text()).toContain("[4/4] b ✓  c ✓  d ✓");
+  });
+
+  test("falls back to one plain line per component off a TTY", () => {
+    const out = makeWriter(false);
+    const progress = new CompactProgress(2, out);
+
+    progress.start("zsh");
+    progress.finish("zsh", "ok");
+    progress.start("git");
+    progress.finish("git", "skipped");
+    progress.end();
+
+    expect(out.text()).toBe("  [1/2] zsh ✓\n  [2/2] git -\n");
+  });
+
+  test("other output starts on its own line instead of the status line", () => {
+    const out = makeWriter(true);
+    const err = makeWriter(false);
+    const progress = new CompactProgress(2, out);
+    const restore = interruptOnWrite(progress, [err]);
+
+    progress.start("zsh");
+    err.write("[error] zsh: boom\n");
+    err.write("[error] zsh: again\n");
+    progress.finish("zsh", "failed");
+    restore();
+    progress.end();
+
+    expect(out.text()).toBe("  [0/2] zsh …\n  [1/2] zsh ✗\n");
+    expect(err.text()).toBe("[error] zsh: boom\n[error] zsh: again\n");
+  });
+
+  test("writes nothing extra when the status line is already closed", () => {
+    const out = makeWriter(true);
+    const progress = new CompactProgress(1, out);
+
+    progress.interrupt();
+    progress.end();
+
+    expect(out.chunks).toEqual([]);
+  });
+});
@@ -1,7 +1,7 @@
 import { describe, test, expect, beforeEach, afterEach } from "bun:test";
-import { parseConfig, resolveComponents, isCheckInstalled } from "../src/config";
+import { parseConfig, resolveComponents, isCheckInstalled, displayName, checkMinVersion } from "../src/config";
 import { tmpdir } from "node:os";
-import { mkdtempSync, writeFileSync, rmSync } from "node:fs";
+import { mkdtempSync, writeFileSync, rmSync, mkdirSync, chmodSync, symlinkSync } from "node:fs";
 import { join } from "node:path";
 
 function makeTempDir(): string {
@@ -40,6 +40,39 @@ install.apt = "sudo apt install zsh"
     });
   });
 
+  test("parses install commands given as argument lists", async () => {
+    writeToml(`
+[ripgrep]
+install.cargo = ["cargo", "install", "ripgrep"]
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].install).toEqual({
+      cargo: ["cargo", "install", "ripgrep"],
+    });
+  });
+
+  test("an unknown ~user in an OS-restricted component doesn't fail the config", async () => {
+    const path = writeToml(`
+[zsh]
+link."zshrc" = "~/.zshrc"
+
+[work]
+os = ["${process.platform === "darwin" ? "linux" : "mac"}"]
+link."profile" = "~dot-no-such-user/.profile"
+`);
+    const config = await parseConfig(path);
+    const resolved = resolveComponents(config, process.platform === "darwin" ? "mac" : "linux");
+    expect(resolved.map((c) => c.name)).toEqual(["zsh"]);
+  });
+
+  test("rejects an empty install argument list", async () => {
+    const path = writeToml(`
+[ripgrep]
+install.cargo = []
+`);
+    await expect(parseConfig(path)).rejects.toThrow("[ripgrep] install.cargo is an empty list");
+  });
+
   test("parses uninstall commands", async () => {
     writeToml(`
 [zsh]
@@ -73,6 +106,55 @@ link."zsh/.zshrc" = ["~/.zshrc", "~/.config/zsh/.zshrc"]
     });
   });
 
+  test("dotfiles expands to links at ~/.<basename>", async () => {
+    const path = writeToml(`
+[git]
+dotfiles = ["git/gitconfig", "zsh/.zshrc", "vim/vimrc/"]
+`);
+    const config = await parseConfig(path);
+    expect(config.components[0].link).toEqual({
+      "git/gitconfig": ["~/.gitconfig"],
+      "zsh/.zshrc": ["~/.zshrc"],
+      "vim/vimrc/": ["~/.vimrc"],
+    });
+  });
+
+  test("dotfiles rejects entries that map to the same target", async () => {
+    const path = writeToml(`
+[x]
+dotfiles = ["a/gitconfig", "b/gitconfig"]
+`);
+    await expect(parseConfig(path)).rejects.toThrow('"a/gitconfig" and "b/gitconfig" both target ~/.gitconfig');
+  });
+
+  test("dotfiles rejects a source that is also in link", async () => {
+    const path = writeToml(`
+[x]
+dotfiles = ["git/gitconfig"]
+link."git/gitconfig" = "~/.config/git/config"
+`);
+    await expect(parseConfig(path)).rejects.toThrow('[x] "git/gitconfig" is in both dotfiles and link');
+  });
+
+  test("throws when two sources link to the same target", async () => {
+    const path = writeToml(`
+[x]
+link."a" = "~/.x"
+link."b" = "~/.x"
+`);
+    await expect(parseConfig(path)).rejects.toThrow('"a" and "b" both target ~/.x');
+  });
+
+  test("throws when targets only match after ~ expansion", async () => {
+    process.env.HOME = "/home/user";
+    const path = writeToml(`
+[x]
+link."a" = "~/.x"
+link."b" = "/home/user/.x"
+`);
+    await expect(parseConfig(path)).rejects.toThrow("both target");
+  });
+
   test("parses postinstall hook", async () => {
     writeToml(`
 [neovim]
@@ -83,6 +165,17 @@ postinstall = "echo done"
     expect(config.components[0].postinstall).toBe("echo done");
   });
 
+  test("parses hooks given as lists", async () => {
+    writeToml(`
+[neovim]
+postinstall = ["echo one", "echo two"]
+postlink = ["echo linked"]
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].postinstall).toEqual(["echo one", "echo two"]);
+    expect(config.components[0].postlink).toEqual(["echo linked"]);
+  });
+
   test("parses postlink hook", async () => {
     writeToml(`
 [ssh]
@@ -167,6 +260,162 @@ install.brew = "brew install zsh"
     expect(config.components[0].name).toBe("zsh");
   });
 
+  test("misspelled component key fails with its line and a suggestion", async () => {
+    const path = writeToml(`
+title = "my dotfiles"
+
+[zsh]
+install.brew = "brew install zsh"
+linl."zsh/.zshrc" = "~/.zshrc"
+`);
+    await expect(parseConfig(path)).rejects.toThrow(`${path}:6: [zsh] unknown key "linl" (did you mean "link"?)`);
+  });
+
+  test("every documented component key is accepted", async () => {
+    const path = writeToml(`
+postrun = "true"
+
+[everything]
+install.any = "true"
+uninstall.any = "true"
+link."a" = "~/.a"
+link."b" = { mac = "~/Library/b", linux = "~/.config/b" }
+postinstall = "true"
+postlink = ["true", "true"]
+defaults."com.apple.dock" = "dock.xml"
+defaults_set."com.apple.dock autohide" = true
+defaults_mode = "merge"
+defaults_format = "xml"
+os = ["mac", "linux"]
+check = "sh"
+description = "All keys"
+shell = "sh"
+if_file_exists = "/"
+unless_file_exists = "/nonexistent"
+allow_missing_source = true
+rollback = true
+success_if = "installed"
+fail_if = "^Error:"
+elevated = true
+retries = 2
+retry_backoff = "exponential"
+retry_delay = 0.5
+`);
+    const config = await parseConfig(path);
+    expect(config.components).toHaveLength(1);
+  });
+
+  test("rejects an invalid fail_if regex", async () => {
+    writeToml(`
+[casks]
+install.brew = "brew install --cask foo"
+fail_if = "(unclosed"
+`);
+    await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow("[casks] fail_if is not a valid regex");
+  });
+
+  test("rejects a negative retries count", async () => {
+    writeToml(`
+[flaky]
+install.any = "true"
+retries = -1
+`);
+    await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow("[flaky] retries must be a non-negative integer");
+  });
+
+  test("rejects an unknown retry_backoff", async () => {
+    writeToml(`
+[flaky]
+install.any = "true"
+retries = 2
+retry_backoff = "linear"
+`);
+    await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow('[flaky] retry_backoff must be "fixed" or "exponential"');
+  });
+
+  test("min_version above the running build is an error", async () => {
+    writeToml(`
+min_version = "99.0.0"
+
+[zsh]
+install.any = "true"
+`);
+    const original = process.env.DOT_VERSION;
+    process.env.DOT_VERSION = "v1.2.0";
+    try {
+      await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow("this config requires dot >= 99.0.0, you have 1.2.0");
+    } finally {
+      if (original === undefined) delete process.env.DOT_VERSION;
+      else process.env.DOT_VERSION = original;
+    }
+  });
+
+  test("min_version is satisfied by newer and development builds", () => {
+    expect(() => checkMinVersion("1.3.0", "v1.3.0")).not.toThrow();
+    expect(() => checkMinVersion("1.3", "1.10.0")).not.toThrow();
+    expect(() => checkMinVersion("1.3.0", "dev")).not.toThrow();
+    expect(() => checkMinVersion("latest", "1.3.0")).toThrow('min_version must look like "1.3.0"');
+  });
+
+  test("min_version reads the release out of git describe versions", () => {
+    expect(() => checkMinVersion("1.2.0", "v1.2.0-3-gabc123")).not.toThrow();
+    expect(() => checkMinVersion("1.3.0", "v1.2.0-3-gabc123")).toThrow("requires dot >= 1.3.0, you have 1.2.0-3-gabc123");
+  });
+
+  test("min_version is skipped with a warning for a bare commit hash", () => {
+    const writes: string[] = [];
+    const write = process.stderr.write;
+    process.stderr.write = ((chunk: string) => {
+      writes.push(chunk);
+      return true;
+    }) as typeof process.stderr.write;
+    try {
+      expect(() => checkMinVersion("99.0.0", "abc1234")).not.toThrow();
+    } finally {
+      process.stderr.write = write;
+    }
+    expect(writes.join("")).toContain("can't tell which release dot abc1234 is");
+  });
+
+  test("parses defaults_currenthost as its own map", async () => {
+    writeToml(`
+[screensaver]
+defaults."com.apple.dock" = "dock.plist"
+defaults_currenthost."com.apple.screensaver" = "screensaver.plist"
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].defaults).toEqual({ "com.apple.dock": "dock.plist" });
+    expect(config.components[0].defaultsCurrentHost).toEqual({ "com.apple.screensaver": "screensaver.plist" });
+    expect(resolveComponents(config, "mac")[0].hasDefaults).toBe(true);
+  });
+
+  test("run_last and run_first pin components in resolved order", async () => {
+    writeToml(`
+[finalize]
+install.any = "true"
+run_last = true
+
+[zsh]
+install.any = "true"
+
+[xcode]
+install.any = "true"
+run_first = true
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(resolveComponents(config, "linux").map((c) => c.name)).toEqual(["xcode", "zsh", "finalize"]);
+  });
+
+  test("rejects run_first and run_last together", async () => {
+    writeToml(`
+[both]
+install.any = "true"
+run_first = true
+run_last = true
+`);
+    await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow("[both] run_first and run_last cannot both be set");
+  });
+
   test("any install key is parsed like any other", async () => {
     writeToml(`
 [neovim]
@@ -188,6 +437,134 @@ check = "zsh"
     expect(config.components[0].check).toBe("zsh");
   });
 
+  test("parses description", async () => {
+    writeToml(`
+[nvim]
+install.brew = "brew install neovim"
+description = "Neovim + plugins"
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].description).toBe("Neovim + plugins");
+    expect(displayName(config.components[0])).toBe("Neovim + plugins");
+  });
+
+  test("display name falls back to the component name", async () => {
+    writeToml(`
+[nvim]
+install.brew = "brew install neovim"
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(displayName(config.components[0])).toBe("nvim");
+  });
+
+  test("parses shell", async () => {
+    writeToml(`
+[tailscale]
+postinstall = "[[ -d /etc ]] && echo ok"
+shell = "bash"
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].shell).toBe("bash");
+  });
+
+  test("parses defaults_mode", async () => {
+    writeToml(`
+[dock]
+defaults."com.apple.dock" = "dock.xml"
+defaults_mode = "merge"
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].defaultsMode).toBe("merge");
+  });
+
+  test("parses defaults_set", async () => {
+    writeToml(`
+[dock]
+defaults_set."com.apple.dock autohide" = true
+defaults_set."com.apple.dock tilesize" = 36
+defaults_set."com.example.app umask" = "0755"
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].defaultsSet).toEqual({
+      "com.apple.dock autohide": true,
+      "com.apple.dock tilesize": 36,
+      "com.example.app umask": "0755",
+    });
+    expect(resolveComponents(config, "mac")[0].hasDefaults).toBe(true);
+  });
+
+  test("rejects a defaults_set key without a domain", async () => {
+    writeToml(`
+[dock]
+defaults_set.autohide = true
+`);
+    await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow("defaults_set");
+  });
+
+  test("defaults_format applies globally unless a component overrides it", async () => {
+    writeToml(`
+defaults_format = "xml"
+
+[dock]
+defaults."com.apple.dock" = "dock.plist"
+
+[finder]
+defaults."com.apple.finder" = "finder.plist"
+defaults_format = "auto"
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].defaultsFormat).toBe("xml");
+    expect(config.components[1].defaultsFormat).toBe("auto");
+  });
+
+  test("rejects an unknown defaults_format", async () => {
+    writeToml(`
+[dock]
+defaults."com.apple.dock" = "dock.plist"
+defaults_format = "binary"
+`);
+    await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow("defaults_format");
+  });
+
+  test("rejects an unknown defaults_mode", async () => {
+    writeToml(`
+[dock]
+defaults."com.apple.dock" = "dock.xml"
+defaults_mode = "overwrite"
+`);
+    await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow("defaults_mode");
+  });
+
+  test("parses allow_missing_source", async () => {
+    writeToml(`
+[generated]
+link."out/config" = "~/.config/generated"
+allow_missing_source = true
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].allowMissingSource).toBe(true);
+  });
+
+  test("parses OS-keyed link targets", async () => {
+    writeToml(`
+[tool]
+link."tool/config" = { darwin = "~/Library/tool/config", linux = ["~/.config/tool/config"] }
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(config.components[0].link).toEqual({});
+    expect(config.components[0].osLinks).toEqual({
+      "tool/config": { mac: ["~/Library/tool/config"], linux: ["~/.config/tool/config"] },
+    });
+  });
+
+  test("rejects an unknown OS in a link map", async () => {
+    writeToml(`
+[tool]
+link."tool/config" = { beos = "~/tool" }
+`);
+    await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow('unknown OS "beos"');
+  });
+
   test("parses check field with shell command", async () => {
     writeToml(`
 [zed]
@@ -233,6 +610,8 @@ describe("resolveComponents", () => {
         }
       }
       if (c.os) toml += `os = [${c.os.map((o: string) => `"${o}"`).join(", ")}]\n`;
+      if (c.if_file_exists) toml += `if_file_exists = "${c.if_file_exists}"\n`;
+      if (c.unless_file_exists) toml += `unless_file_exists = "${c.unless_file_exists}"\n`;
     }
     writeFileSync(join(tmp, "dot.toml"), toml);
     return join(tmp, "dot.toml");
@@ -274,6 +653,37 @@ describe("resolveComponents", () => {
     expect(resolved[0].installCommand).toBeNull();
   });
 
+  test("skips disabled managers even when they are on PATH", async () => {
+    const bin = join(tmp, "bin");
+    mkdirSync(bin);
+    for (const mgr of ["snap", "brew"]) {
+      writeFileSync(join(bin, mgr), "#!/bin/sh\n");
+      chmodSync(join(bin, mgr), 0o755);
+    }
+    writeFileSync(join(tmp, "dot.toml"), `
+disabled_managers = ["snap"]
+
+[btop]
+install.snap = "snap install btop"
+install.brew = "brew install btop"
+`);
+    const path = process.env.PATH;
+    process.env.PATH = bin;
+    try {
+      const config = await parseConfig(join(tmp, "dot.toml"));
+      expect(resolveComponents(config, "linux")[0].installCommand).toBe("brew install btop");
+      expect(resolveComponents({ ...config, disabledManagers: [] }, "linux")[0].availableManager).toBe("snap");
+      expect(resolveComponents({ ...config, disabledManagers: [] }, "linux", ["snap"])[0].availableManager).toBe("brew");
+    } finally {
+      process.env.PATH = path;
+    }
+  });
+
+  test("rejects a disabled_managers value that is not a list", async () => {
+    writeFileSync(join(tmp, "dot.toml"), `disabled_managers = "snap"\n`);
+    await expect(parseConfig(join(tmp, "dot.toml"))).rejects.toThrow("disabled_managers must be a list of manager names");
+  });
+
   test("filters by OS (matches)", async () => {
     await makeConfig([{
       name: "zsh",
@@ -377,6 +787,46 @@ describe("resolveComponents", () => {
     expect(resolved[0].isInstalled).toBe(false);
   });
 
+  test("OS-keyed links resolve to the current OS target", async () => {
+    writeFileSync(join(tmp, "dot.toml"), `
+[tool]
+link."gitconfig" = "~/.gitconfig"
+link."tool/config" = { mac = "~/Library/tool/config", linux = "~/.config/tool/config" }
+`);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+
+    expect(resolveComponents(config, "mac")[0].link).toEqual({
+      "gitconfig": ["~/.gitconfig"],
+      "tool/config": ["~/Library/tool/config"],
+    });
+    expect(resolveComponents(config, "linux")[0].link["tool/config"]).toEqual(["~/.config/tool/config"]);
+    expect(resolveComponents(config, "windows")[0].link).toEqual({ "gitconfig": ["~/.gitconfig"] });
+  });
+
+  test("if_file_exists gates on a present file", async () => {
+    writeFileSync(join(tmp, "plugged"), "");
+    await makeConfig([
+      { name: "present", install: { any: "true" }, if_file_exists: join(tmp, "plugged") },
+      { name: "absent", install: { any: "true" }, if_file_exists: join(tmp, "missing") },
+    ]);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    const resolved = resolveComponents(config, "linux");
+    expect(resolved[0].skipReason).toBeNull();
+    expect(resolved[1].skipReason).toContain("does not exist");
+  });
+
+  test("unless_file_exists gates on an absent file", async () => {
+    writeFileSync(join(tmp, "marker"), "");
+    await makeConfig([
+      { name: "present", install: { any: "true" }, unless_file_exists: join(tmp, "marker") },
+      { name: "absent", install: { any: "true" }, unless_file_exists: join(tmp, "missing") },
+    ]);
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    const resolved = resolveComponents(config, "linux");
+    expect(resolved[0].skipReason).toContain("exists");
+    expect(resolved[1].skipReason).toBeNull();
+  });
+
   test("check with shell command sets isInstalled", async () => {
     await makeConfig([{
       name: "custom",
@@ -407,6 +857,34 @@ describe("resolveComponents", () => {
   });
 });
 
+describe("resolveComponents with a target prefix", () => {
+  let tmp: string;
+  const originalCwd = process.cwd();
+
+  beforeEach(() => {
+    tmp = makeTempDir();
+    process.chdir(tmp);
+  });
+
+  afterEach(() => {
+    process.chdir(originalCwd);
+    rmSync(tmp, { recursive: true, force: true });
+  });
+
+  test("counts links as done only where --target-prefix puts them", async () => {
+    const prefix = join(tmp, "rootfs");
+    const target = join(tmp, "home", ".zshrc");
+    writeFileSync(join(tmp, "zshrc"), "# zsh\n");
+    writeFileSync(join(tmp, "dot.toml"), `[zsh]\nlink."zshrc" = "${target}"\n`);
+    mkdirSync(join(prefix, tmp, "home"), { recursive: true });
+    symlinkSync(join(tmp, "zshrc"), join(prefix, target));
+
+    const config = await parseConfig(join(tmp, "dot.toml"));
+    expect(resolveComponents(config, "linux")[0].allLinksDone).toBe(false);
+    expect(resolveComponents(config, "linux", [], prefix)[0].allLinksDone).toBe(true);
+  });
+});
+
 describe("isCheckInstalled", () => {
   test("binary name: found", () => {
     expect(isCheckInstalled("sh")).toBe(true);
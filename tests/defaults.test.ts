@@ -1,7 +1,7 @@
 import { describe, test, expect, beforeEach, afterEach } from "bun:test";
-import { exportDefaults, importDefaults } from "../src/defaults";
+import { exportDefaults, importDefaults, defaultsWriteArgs, setDefaults, exportCommand, defaultsCommand, plistEntries } from "../src/defaults";
 import { tmpdir } from "node:os";
-import { mkdtempSync, writeFileSync, rmSync, existsSync } from "node:fs";
+import { mkdtempSync, writeFileSync, rmSync, existsSync, readFileSync } from "node:fs";
 import { join } from "node:path";
 
 function makeTempDir(): string {
@@ -40,6 +40,38 @@ describe("exportDefaults", () => {
     expect(result).toEqual([]);
   });
 
+  test("dry run reports whether the stored file would change", async () => {
+    if (process.platform !== "darwin") return;
+    const current = Bun.spawnSync(["defaults", "export", "com.apple.dock", "-"], { stdout: "pipe" }).stdout;
+    writeFileSync(join(tmp, "same.xml"), current);
+    writeFileSync(join(tmp, "stale.xml"), "stale");
+
+    const result = await exportDefaults(
+      { "com.apple.dock": "same.xml" },
+      tmp,
+      { dryRun: true, verbose: false, interactive: false }
+    );
+    const stale = await exportDefaults(
+      { "com.apple.dock": "stale.xml" },
+      tmp,
+      { dryRun: true, verbose: false, interactive: false }
+    );
+
+    expect(result[0].changed).toBe(false);
+    expect(stale[0].changed).toBe(true);
+    expect(readFileSync(join(tmp, "stale.xml"), "utf8")).toBe("stale");
+  });
+
+  test("defaultsFormat xml exports XML regardless of extension", async () => {
+    if (process.platform !== "darwin") return;
+    await exportDefaults(
+      { "com.apple.dock": "dock.plist" },
+      tmp,
+      { dryRun: false, verbose: false, interactive: false, defaultsFormat: "xml" }
+    );
+    expect(readFileSync(join(tmp, "dock.plist"), "utf8")).toStartWith("<?xml");
+  });
+
   test("returns component name for each domain", async () => {
     if (process.platform === "darwin") return;
     const file = join(tmp, "dock.plist");
@@ -48,6 +80,42 @@ describe("exportDefaults", () => {
   });
 });
 
+describe("currentHost", () => {
+  let tmp: string;
+
+  beforeEach(() => {
+    tmp = makeTempDir();
+  });
+
+  afterEach(() => {
+    rmSync(tmp, { recursive: true, force: true });
+  });
+
+  test("puts -currentHost before the verb", () => {
+    expect(exportCommand("com.apple.screensaver", "screensaver.plist", undefined, true))
+      .toEqual(["defaults", "-currentHost", "read", "com.apple.screensaver"]);
+    expect(exportCommand("com.apple.screensaver", "screensaver.xml", undefined, true))
+      .toEqual(["defaults", "-currentHost", "export", "com.apple.screensaver", "-"]);
+    expect(defaultsCommand(["import", "com.apple.screensaver", "/tmp/s.plist"], true))
+      .toEqual(["defaults", "-currentHost", "import", "com.apple.screensaver", "/tmp/s.plist"]);
+    expect(exportCommand("com.apple.dock", "dock.plist")).toEqual(["defaults", "read", "com.apple.dock"]);
+  });
+
+  test("exports a per-host domain on macOS", async () => {
+    if (process.platform !== "darwin") return;
+    const expected = Bun.spawnSync(["defaults", "-currentHost", "export", "com.apple.screensaver", "-"], { stdout: "pipe" }).stdout;
+
+    const result = await exportDefaults(
+      { "com.apple.screensaver": "screensaver.xml" },
+      tmp,
+      { dryRun: false, verbose: false, interactive: false, currentHost: true }
+    );
+
+    expect(result[0].success).toBe(true);
+    expect(readFileSync(join(tmp, "screensaver.xml"))).toEqual(Buffer.from(expected));
+  });
+});
+
 describe("importDefaults", () => {
   let tmp: string;
 
@@ -68,6 +136,18 @@ describe("importDefaults", () => {
     expect(result[0].reason).toContain("macOS");
   });
 
+  test("refuses a malformed plist before touching defaults", async () => {
+    if (process.platform !== "darwin") return;
+    const domain = "dev.dot.test.lint";
+    writeFileSync(join(tmp, "broken.plist"), "<plist><dict><key>a</key></plist");
+
+    const result = await importDefaults({ [domain]: "broken.plist" }, tmp, { dryRun: false, verbose: false, interactive: false });
+
+    expect(result[0].failed).toBe(true);
+    expect(result[0].reason).toContain("plist file is invalid:");
+    expect(Bun.spawnSync(["defaults", "read", domain], { stderr: "ignore" }).exitCode).not.toBe(0);
+  });
+
   test("dry run sets flag", async () => {
     const file = join(tmp, "dock.plist");
     writeFileSync(file, "mock plist content");
@@ -98,3 +178,146 @@ describe("importDefaults", () => {
     expect(result[0].domain).toBe("com.apple.dock");
   });
 });
+
+describe("merge mode", () => {
+  const domain = "com.github.pablopunk.dot.test";
+  let tmp: string;
+
+  beforeEach(() => {
+    tmp = makeTempDir();
+  });
+
+  afterEach(() => {
+    rmSync(tmp, { recursive: true, force: true });
+    if (process.platform === "darwin") Bun.spawnSync(["defaults", "delete", domain]);
+  });
+
+  test("maps plist values to defaults write arguments", () => {
+    expect(defaultsWriteArgs(true)).toEqual(["-bool", "true"]);
+    expect(defaultsWriteArgs(36)).toEqual(["-int", "36"]);
+    expect(defaultsWriteArgs(0.5)).toEqual(["-float", "0.5"]);
+    expect(defaultsWriteArgs("left")).toEqual(["-string", "left"]);
+    expect(defaultsWriteArgs(["a", 1])).toEqual(["<array><string>a</string><integer>1</integer></array>"]);
+    expect(defaultsWriteArgs({ k: false })).toEqual(["<dict><key>k</key><false/></dict>"]);
+  });
+
+  test("writes tracked keys and keeps untracked ones", async () => {
+    if (process.platform !== "darwin") return;
+    Bun.spawnSync(["defaults", "write", domain, "untracked", "-string", "keep"]);
+    Bun.spawnSync(["defaults", "write", domain, "tilesize", "-int", "48"]);
+    writeFileSync(join(tmp, "partial.xml"), `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+  <key>tilesize</key>
+  <integer>36</integer>
+</dict>
+</plist>
+`);
+
+    const result = await importDefaults(
+      { [domain]: "partial.xml" },
+      tmp,
+      { dryRun: false, verbose: false, interactive: false, defaultsMode: "merge" }
+    );
+
+    const read = (key: string) => Buffer.from(Bun.spawnSync(["defaults", "read", domain, key], { stdout: "pipe" }).stdout).toString().trim();
+    expect(result[0].success).toBe(true);
+    expect(read("tilesize")).toBe("36");
+    expect(read("untracked")).toBe("keep");
+  });
+
+  const nested = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>lastOpened</key>
+	<date>2024-05-01T12:00:00Z</date>
+	<key>window &amp; tabs</key>
+	<dict>
+		<key>width</key>
+		<integer>800</integer>
+		<key>tabs</key>
+		<array>
+			<string>a</string>
+			<dict/>
+		</array>
+	</dict>
+	<key>empty</key>
+	<array/>
+</dict>
+</plist>
+`;
+
+  test("splits a plist into top-level keys with their raw values", () => {
+    expect(plistEntries(nested)).toEqual([
+      ["lastOpened", "<date>2024-05-01T12:00:00Z</date>"],
+      ["window & tabs", "<dict>\n\t\t<key>width</key>\n\t\t<integer>800</integer>\n\t\t<key>tabs</key>\n\t\t<array>\n\t\t\t<string>a</string>\n\t\t\t<dict/>\n\t\t</array>\n\t</dict>"],
+      ["empty", "<array/>"],
+    ]);
+    expect(plistEntries(`<plist version="1.0">\n<dict/>\n</plist>`)).toEqual([]);
+    expect(plistEntries(`<plist version="1.0"><array/></plist>`)).toBeNull();
+  });
+
+  test("merges dates and nested dicts without changing their types", async () => {
+    if (process.platform !== "darwin") return;
+    writeFileSync(join(tmp, "nested.xml"), nested);
+
+    const result = await importDefaults(
+      { [domain]: "nested.xml" },
+      tmp,
+      { dryRun: false, verbose: false, interactive: false, defaultsMode: "merge" }
+    );
+
+    const readType = (key: string) =>
+      Buffer.from(Bun.spawnSync(["defaults", "read-type", domain, key], { stdout: "pipe" }).stdout).toString().trim();
+    const exported = Buffer.from(Bun.spawnSync(["defaults", "export", domain, "-"], { stdout: "pipe" }).stdout).toString();
+    expect(result[0].success).toBe(true);
+    expect(readType("lastOpened")).toBe("Type is date");
+    expect(readType("window & tabs")).toBe("Type is dictionary");
+    expect(exported).toContain("<date>2024-05-01T12:00:00Z</date>");
+    expect(exported).toContain("<integer>800</integer>");
+  });
+});
+
+describe("setDefaults", () => {
+  const domain = "com.github.pablopunk.dot.test";
+  const read = (key: string) => Buffer.from(Bun.spawnSync(["defaults", "read", domain, key], { stdout: "pipe" }).stdout).toString().trim();
+
+  afterEach(() => {
+    if (process.platform === "darwin") Bun.spawnSync(["defaults", "delete", domain]);
+  });
+
+  test("writes values with the type TOML gave them", () => {
+    expect(defaultsWriteArgs(true)).toEqual(["-bool", "true"]);
+    expect(defaultsWriteArgs(36)).toEqual(["-int", "36"]);
+    expect(defaultsWriteArgs(0.5)).toEqual(["-float", "0.5"]);
+    expect(defaultsWriteArgs("0755")).toEqual(["-string", "0755"]);
+  });
+
+  test("skips on non-macOS", async () => {
+    if (process.platform === "darwin") return;
+    const result = await setDefaults({ "com.apple.dock autohide": true }, { dryRun: false, verbose: false, interactive: false });
+    expect(result[0].skipped).toBe(true);
+    expect(result[0].domain).toBe("com.apple.dock");
+    expect(result[0].file).toBe("autohide");
+  });
+
+  test("writes a bool and skips it once it matches", async () => {
+    if (process.platform !== "darwin") return;
+    const options = { dryRun: false, verbose: false, interactive: false };
+    const first = await setDefaults({ [`${domain} autohide`]: "true" }, options);
+    const second = await setDefaults({ [`${domain} autohide`]: true }, options);
+
+    expect(first[0].changed).toBe(true);
+    expect(read("autohide")).toBe("1");
+    expect(second[0].skipped).toBe(true);
+  });
+
+  test("writes an int", async () => {
+    if (process.platform !== "darwin") return;
+    const result = await setDefaults({ [`${domain} tilesize`]: 36 }, { dryRun: false, verbose: false, interactive: false });
+    expect(result[0].success).toBe(true);
+    expect(read("tilesize")).toBe("36");
+  });
+});
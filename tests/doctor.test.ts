@@ -0,0 +1,56 @@
+import { describe, test, expect, beforeEach, afterEach } from "bun:test";
+import { diagnose } from "../src/doctor";
+import { tmpdir } from "node:os";
+import { mkdtempSync, writeFileSync, rmSync } from "node:fs";
+import { join } from "node:path";
+
+function makeTempDir(): string {
+  return mkdtempSync(join(tmpdir(), "dot-doctor-test-"));
+}
+
+describe("diagnose", () => {
+  let tmp: string;
+
+  beforeEach(() => {
+    tmp = makeTempDir();
+  });
+
+  afterEach(() => {
+    rmSync(tmp, { recursive: true, force: true });
+  });
+
+  test("reports os, config, and managers", async () => {
+    writeFileSync(join(tmp, "dot.toml"), `
+[zsh]
+install.sh = "sh -c true"
+install.dot-missing-manager = "nope"
+`);
+    const checks = await diagnose(join(tmp, "dot.toml"));
+    const byLabel = Object.fromEntries(checks.map((c) => [c.label, c]));
+
+    expect(byLabel.os.status).toBe("pass");
+    expect(byLabel.os.detail).toContain(process.arch);
+    expect(byLabel.config.status).toBe("pass");
+    expect(byLabel.sh.status).toBe("pass");
+    expect(byLabel["dot-missing-manager"].status).toBe("warn");
+    expect(byLabel.zsh).toBeUndefined();
+  });
+
+  test("fails components with no available manager", async () => {
+    writeFileSync(join(tmp, "dot.toml"), `
+[ghost]
+install.dot-missing-manager = "nope"
+`);
+    const checks = await diagnose(join(tmp, "dot.toml"));
+    const ghost = checks.find((c) => c.label === "ghost");
+    expect(ghost?.status).toBe("fail");
+    expect(ghost?.detail).toContain("dot-missing-manager");
+  });
+
+  test("fails when the config is missing", async () => {
+    const checks = await diagnose(join(tmp, "dot.toml"));
+    const config = checks.find((c) => c.label === "config");
+    expect(config?.status).toBe("fail");
+    expect(config?.detail).toContain("not found");
+  });
+});
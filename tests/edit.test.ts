@@ -0,0 +1,65 @@
+import { describe, test, expect, beforeEach, afterEach } from "bun:test";
+import { editorCommand } from "../src/edit";
+import { mkdtempSync, rmSync, writeFileSync, chmodSync, readFileSync } from "node:fs";
+import { tmpdir } from "node:os";
+import { join } from "node:path";
+
+let repoDir: string;
+
+beforeEach(() => {
+  repoDir = mkdtempSync(join(tmpdir(), "dot-edit-"));
+  writeFileSync(join(repoDir, "dot.toml"), `[zsh]\ninstall.any = "true"\n`);
+});
+
+afterEach(() => {
+  rmSync(repoDir, { recursive: true, force: true });
+});
+
+function fakeEditor(script: string): string {
+  const path = join(repoDir, "editor.sh");
+  writeFileSync(path, `#!/bin/sh\n${script}\n`);
+  chmodSync(path, 0o755);
+  return path;
+}
+
+async function runEdit(editor: string) {
+  const child = Bun.spawn([process.execPath, join(import.meta.dir, "../src/index.ts"), "--edit"], {
+    cwd: repoDir,
+    env: { ...process.env, EDITOR: editor, VISUAL: "" },
+    stdout: "pipe",
+    stderr: "pipe",
+  });
+  const [stdout, stderr] = await Promise.all([
+    new Response(child.stdout).text(),
+    new Response(child.stderr).text(),
+  ]);
+  return { exitCode: await child.exited, stdout, stderr };
+}
+
+describe("editorCommand", () => {
+  test("prefers VISUAL, then EDITOR", () => {
+    expect(editorCommand({ VISUAL: "code -w", EDITOR: "vim" })).toBe("code -w");
+    expect(editorCommand({ EDITOR: "vim" })).toBe("vim");
+  });
+});
+
+describe("--edit", () => {
+  test("reports a validation error the editor introduced", async () => {
+    const editor = fakeEditor(`printf '[zsh]\\nlinl."zshrc" = "~/.zshrc"\\n' > "$1"`);
+
+    const { exitCode, stderr } = await runEdit(editor);
+
+    expect(exitCode).toBe(1);
+    expect(stderr).toContain('unknown key "linl"');
+    expect(readFileSync(join(repoDir, "dot.toml"), "utf-8")).toContain("linl");
+  });
+
+  test("confirms a valid config", async () => {
+    const editor = fakeEditor(`printf '[git]\\ninstall.any = "true"\\n' > "$1"`);
+
+    const { exitCode, stdout } = await runEdit(editor);
+
+    expect(exitCode).toBe(0);
+    expect(stdout).toContain("dot.toml is valid");
+  });
+});
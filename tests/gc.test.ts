@@ -0,0 +1,69 @@
+import { describe, test, expect, beforeEach, afterEach } from "bun:test";
+import { parseAge, collectBackups } from "../src/gc";
+import { tmpdir } from "node:os";
+import { mkdtempSync, writeFileSync, rmSync, existsSync, utimesSync } from "node:fs";
+import { join } from "node:path";
+
+function makeTempDir(): string {
+  return mkdtempSync(join(tmpdir(), "dot-gc-test-"));
+}
+
+const DAY = 24 * 60 * 60 * 1000;
+
+describe("parseAge", () => {
+  test("parses seconds, minutes, hours, and days", () => {
+    expect(parseAge("30s")).toBe(30 * 1000);
+    expect(parseAge("45m")).toBe(45 * 60 * 1000);
+    expect(parseAge("12h")).toBe(12 * 60 * 60 * 1000);
+    expect(parseAge("30d")).toBe(30 * DAY);
+  });
+
+  test("rejects anything else", () => {
+    expect(parseAge("30")).toBeNull();
+    expect(parseAge("1w")).toBeNull();
+    expect(parseAge("-1d")).toBeNull();
+  });
+});
+
+describe("collectBackups", () => {
+  let home: string;
+
+  beforeEach(() => {
+    home = makeTempDir();
+  });
+
+  afterEach(() => {
+    rmSync(home, { recursive: true, force: true });
+  });
+
+  function backup(name: string, ageDays: number): string {
+    const path = join(home, name);
+    writeFileSync(path, "old");
+    const when = new Date(Date.now() - ageDays * DAY);
+    utimesSync(path, when, when);
+    return path;
+  }
+
+  test("removes old backups of configured targets only", () => {
+    const old = backup(".zshrc.dot.bak", 40);
+    const recent = backup(".gitconfig.dot.bak", 1);
+    const unrelated = backup(".vimrc.dot.bak", 40);
+    const links = [{ "zshrc": [join(home, ".zshrc")], "gitconfig": [join(home, ".gitconfig")] }];
+
+    const results = collectBackups(links, 30 * DAY, { dryRun: false, verbose: false, interactive: false });
+
+    expect(results.map((r) => r.path)).toEqual([old]);
+    expect(results[0].removed).toBe(true);
+    expect(existsSync(old)).toBe(false);
+    expect(existsSync(recent)).toBe(true);
+    expect(existsSync(unrelated)).toBe(true);
+  });
+
+  test("dry run lists candidates without removing them", () => {
+    const old = backup(".zshrc.dot.bak", 40);
+    const results = collectBackups([{ "zshrc": [join(home, ".zshrc")] }], 30 * DAY, { dryRun: true, verbose: false, interactive: false });
+
+    expect(results[0].dryRun).toBe(true);
+    expect(existsSync(old)).toBe(true);
+  });
+});
@@ -1,5 +1,8 @@
 import { describe, test, expect } from "bun:test";
 import { runPostInstall, runPostLink } from "../src/hooks";
+import { mkdtempSync, rmSync, existsSync } from "node:fs";
+import { tmpdir } from "node:os";
+import { join } from "node:path";
 
 describe("runPostInstall", () => {
   test("runs hook and returns success", async () => {
@@ -24,6 +27,49 @@ describe("runPostInstall", () => {
     const result = await runPostInstall("zsh", "exit 1", { dryRun: false, verbose: false, interactive: false });
     expect(result.failed).toBe(true);
   });
+
+  test("keeps what the hook printed, including a failing step", async () => {
+    const result = await runPostInstall(
+      "zsh",
+      ["echo compiled", "echo broke >&2; exit 1"],
+      { dryRun: false, verbose: false, interactive: false }
+    );
+    expect(result.failed).toBe(true);
+    expect(result.output).toBe("compiled\nbroke\n");
+  });
+});
+
+describe("hook lists", () => {
+  test("runs every step in order", async () => {
+    const result = await runPostInstall(
+      "zsh",
+      ["echo one", "echo two"],
+      { dryRun: false, verbose: false, interactive: false }
+    );
+    expect(result.success).toBe(true);
+  });
+
+  test("stops at the first failing step", async () => {
+    const dir = mkdtempSync(join(tmpdir(), "dot-hooks-"));
+    const marker = join(dir, "third-step");
+    try {
+      const result = await runPostLink(
+        "zsh",
+        ["true", "exit 3", `touch ${marker}`],
+        { dryRun: false, verbose: false, interactive: false }
+      );
+      expect(result.failed).toBe(true);
+      expect(result.failedStep).toBe(2);
+      expect(existsSync(marker)).toBe(false);
+    } finally {
+      rmSync(dir, { recursive: true, force: true });
+    }
+  });
+
+  test("empty list is skipped", async () => {
+    const result = await runPostInstall("zsh", [], { dryRun: false, verbose: false, interactive: false });
+    expect(result.skipped).toBe(true);
+  });
 });
 
 describe("runPostLink", () => {
@@ -50,3 +96,39 @@ describe("runPostLink", () => {
     expect(result.failed).toBe(true);
   });
 });
+
+describe("hook shell", () => {
+  test("runs bash-only constructs under shell = bash", async () => {
+    if (process.platform === "win32" || !Bun.which("bash")) return;
+    const result = await runPostInstall(
+      "zsh",
+      'arr=(a b); [[ "${arr[1]}" == "b" ]]',
+      { dryRun: false, verbose: false, interactive: false, shell: "bash" }
+    );
+    expect(result.success).toBe(true);
+  });
+
+  test("fails when the shell does not exist", async () => {
+    if (process.platform === "win32") return;
+    const result = await runPostLink(
+      "zsh",
+      "true",
+      { dryRun: false, verbose: false, interactive: false, shell: "nonexistentshellxyz" }
+    );
+    expect(result.failed).toBe(true);
+  });
+});
+
+describe("hook env", () => {
+  test("passes env to hooks with and without a shell", async () => {
+    if (process.platform === "win32") return;
+    const env = { DOT_INSTALL_RAN: "true", DOT_LINK_CHANGED: "false" };
+    const check = '[ "$DOT_INSTALL_RAN" = true ] && [ "$DOT_LINK_CHANGED" = false ]';
+
+    const builtin = await runPostLink("zsh", check, { dryRun: false, verbose: false, interactive: false, env });
+    const sh = await runPostLink("zsh", check, { dryRun: false, verbose: false, interactive: false, shell: "sh", env });
+
+    expect(builtin.success).toBe(true);
+    expect(sh.success).toBe(true);
+  });
+});
@@ -1,6 +1,6 @@
 import { describe, test, expect, beforeEach, afterEach } from "bun:test";
-import { installComponent, uninstallComponent } from "../src/installer";
-import { mkdtempSync, rmSync, existsSync } from "node:fs";
+import { installComponent, uninstallComponent, effectiveRetries, retryDelay } from "../src/installer";
+import { mkdtempSync, rmSync, existsSync, readFileSync } from "node:fs";
 import { tmpdir } from "node:os";
 import { join } from "node:path";
 
@@ -32,6 +32,24 @@ describe("installComponent", () => {
     expect(result.failed).toBe(true);
   });
 
+  test("expandDryRun previews $HOME expanded and $(date) literal", async () => {
+    const writes: string[] = [];
+    const write = process.stdout.write;
+    process.stdout.write = ((chunk: string) => {
+      writes.push(chunk);
+      return true;
+    }) as typeof process.stdout.write;
+    try {
+      await installComponent("bin", "cp tool $HOME/bin/tool-$(date +%s)", {
+        dryRun: true, verbose: false, interactive: false, report: true, expandDryRun: true,
+      });
+    } finally {
+      process.stdout.write = write;
+    }
+
+    expect(writes.join("")).toContain(`cp tool ${process.env.HOME}/bin/tool-$(date +%s)`);
+  });
+
   test("reports component name in result", async () => {
     const result = await installComponent("neovim", "echo ok", { dryRun: false, verbose: false, interactive: false });
     expect(result.component).toBe("neovim");
@@ -48,6 +66,40 @@ describe("installComponent", () => {
     expect(result.failed).toBe(true);
   });
 
+  test("runs the command under the requested shell", async () => {
+    if (process.platform === "win32") return;
+    const marker = join(tmp, "shell-name");
+    const result = await installComponent(
+      "zsh",
+      `basename "$0" > ${marker}`,
+      { dryRun: false, verbose: false, interactive: false, shell: "sh" }
+    );
+
+    expect(result.success).toBe(true);
+    expect(readFileSync(marker, "utf8").trim()).toBe("sh");
+  });
+
+  test("list commands run without a shell", async () => {
+    const target = join(tmp, "a $HOME;b");
+    const result = await installComponent(
+      "literal",
+      ["touch", target],
+      { dryRun: false, verbose: false, interactive: false }
+    );
+
+    expect(result.success).toBe(true);
+    expect(existsSync(target)).toBe(true);
+  });
+
+  test("list commands fail when the binary is missing", async () => {
+    const result = await installComponent(
+      "missing",
+      ["nonexistentcommandxyz123", "install"],
+      { dryRun: false, verbose: false, interactive: false }
+    );
+    expect(result.failed).toBe(true);
+  });
+
   test("non-interactive commands preserve pipeline input", async () => {
     const marker = join(tmp, "mise-installed");
     const result = await installComponent(
@@ -59,6 +111,89 @@ describe("installComponent", () => {
     expect(result.success).toBe(true);
     expect(existsSync(marker)).toBe(true);
   });
+
+  test("fail_if marks a zero-exit install as failed", async () => {
+    const result = await installComponent(
+      "casks",
+      "echo 'Error: already installed but broken'",
+      { dryRun: false, verbose: false, interactive: false, failIf: "^Error:" }
+    );
+
+    expect(result.failed).toBe(true);
+    expect(result.success).toBe(false);
+  });
+
+  test("success_if must match for the install to succeed", async () => {
+    const opts = { dryRun: false, verbose: false, interactive: false, successIf: "installed \\d+" };
+    expect((await installComponent("tool", "echo installed 3", opts)).success).toBe(true);
+    expect((await installComponent("tool", "echo nothing to do", opts)).failed).toBe(true);
+  });
+
+  test("success_if match overrides a nonzero exit code", async () => {
+    const result = await installComponent(
+      "tool",
+      "echo 'installed 1'; exit 1",
+      { dryRun: false, verbose: false, interactive: false, successIf: "installed" }
+    );
+
+    expect(result.success).toBe(true);
+  });
+});
+
+describe("retries", () => {
+  test("--max-retries is the default and a cap", () => {
+    expect(effectiveRetries(undefined, undefined)).toBe(0);
+    expect(effectiveRetries(undefined, 3)).toBe(3);
+    expect(effectiveRetries(5, undefined)).toBe(5);
+    expect(effectiveRetries(5, 2)).toBe(2);
+    expect(effectiveRetries(1, 4)).toBe(1);
+  });
+
+  test("retries a flaky install and reports the attempt count", async () => {
+    const counter = join(tmp, "count");
+    const command = `n=$(cat ${counter} 2>/dev/null || echo 0); n=$((n+1)); echo $n > ${counter}; [ $n -ge 3 ]`;
+
+    const result = await installComponent("flaky", command, {
+      dryRun: false, verbose: false, interactive: false, retries: 5, maxRetries: 4,
+    });
+
+    expect(result.success).toBe(true);
+    expect(result.attempts).toBe(3);
+  });
+
+  test("gives up after the capped number of attempts", async () => {
+    const counter = join(tmp, "count");
+    const command = `n=$(cat ${counter} 2>/dev/null || echo 0); echo $((n+1)) > ${counter}; false`;
+
+    const result = await installComponent("broken", command, {
+      dryRun: false, verbose: false, interactive: false, retries: 5, maxRetries: 1,
+    });
+
+    expect(result.failed).toBe(true);
+    expect(result.attempts).toBe(2);
+    expect(readFileSync(counter, "utf-8").trim()).toBe("2");
+  });
+
+  test("waits nothing between retries unless a backoff or delay is set", () => {
+    expect(retryDelay(1, undefined, undefined)).toBe(0);
+    expect(retryDelay(3, "fixed", 2)).toBe(2000);
+    expect(retryDelay(3, "exponential", 1, () => 0)).toBe(4000);
+  });
+
+  test("exponential backoff sleeps longer after each failed attempt", async () => {
+    const sleeps: number[] = [];
+    const result = await installComponent("broken", "false", {
+      dryRun: false, verbose: false, interactive: false,
+      retries: 3, retryBackoff: "exponential", retryDelay: 0.001,
+      sleep: async (ms) => { sleeps.push(ms); },
+    });
+
+    expect(result.attempts).toBe(4);
+    expect(sleeps).toHaveLength(3);
+    expect(sleeps[0]).toBeGreaterThanOrEqual(1);
+    expect(sleeps[1]).toBeGreaterThan(sleeps[0]);
+    expect(sleeps[2]).toBeGreaterThan(sleeps[1]);
+  });
 });
 
 describe("uninstallComponent", () => {
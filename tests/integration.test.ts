@@ -1,6 +1,6 @@
 import { describe, test, expect, beforeEach, afterEach } from "bun:test";
 import { tmpdir } from "node:os";
-import { mkdtempSync, writeFileSync, rmSync, existsSync, readlinkSync, mkdirSync } from "node:fs";
+import { mkdtempSync, writeFileSync, rmSync, existsSync, readlinkSync, mkdirSync, symlinkSync, readFileSync, lstatSync, chmodSync, utimesSync } from "node:fs";
 import { join } from "node:path";
 import prompts from "prompts";
 import { parseConfig, resolveComponents } from "../src/config";
@@ -12,6 +12,43 @@ function makeTempDir(): string {
   return mkdtempSync(join(tmpdir(), "dot-integration-"));
 }
 
+function stripAnsi(text: string): string {
+  return text.replace(/\x1B\[[0-?]*[ -/]*[@-~]/g, "");
+}
+
+function git(cwd: string, ...args: string[]): void {
+  const proc = Bun.spawnSync(["git", "-c", "user.name=dot", "-c", "user.email=dot@example.com", ...args], { cwd });
+  if (proc.exitCode !== 0) throw new Error(proc.stderr.toString());
+}
+
+interface DotProcess {
+  cwd: string;
+  home: string;
+  stdin?: Blob | "ignore";
+  // Runs before dot, e.g. setsid to drop the controlling terminal.
+  launcher?: string[];
+  // Sends SIGINT this many milliseconds after starting.
+  interruptAfter?: number;
+}
+
+// Runs dot as its own process, the way a shell would, with HOME set to home.
+async function runDot(args: string[], opts: DotProcess): Promise<{ exitCode: number; stdout: string; stderr: string }> {
+  const child = Bun.spawn([...(opts.launcher ?? []), process.execPath, join(import.meta.dir, "../src/index.ts"), ...args], {
+    cwd: opts.cwd,
+    env: { ...process.env, HOME: opts.home },
+    stdin: opts.stdin,
+    stdout: "pipe",
+    stderr: "pipe",
+  });
+  if (opts.interruptAfter !== undefined) setTimeout(() => child.kill("SIGINT"), opts.interruptAfter);
+  const [exitCode, stdout, stderr] = await Promise.all([
+    child.exited,
+    new Response(child.stdout).text(),
+    new Response(child.stderr).text(),
+  ]);
+  return { exitCode, stdout, stderr };
+}
+
 describe("integration", () => {
   let repoDir: string;
   let homeDir: string;
@@ -94,6 +131,53 @@ install.any = "printf 'touch ${marker}' | sh"
     }
   });
 
+  test("a piped launcher keeps its input away from install commands", async () => {
+    const marker = join(repoDir, "mise-installed");
+    const captured = join(repoDir, "captured");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[mise]
+install.any = "cat > ${captured}; printf 'touch ${marker}' | sh"
+`);
+
+    const { exitCode } = await runDot(["-i", "mise"], { cwd: repoDir, home: homeDir, stdin: new Blob(["mise\n"]) });
+
+    expect(exitCode).toBe(0);
+    expect(readFileSync(captured, "utf8")).toBe("");
+    expect(existsSync(marker)).toBe(true);
+  });
+
+  test("headless runs with stdin from /dev/null still install named components", async () => {
+    const marker = join(repoDir, "mise-installed");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[mise]
+install.any = "cat; printf 'touch ${marker}' | sh"
+`);
+
+    const { exitCode } = await runDot(["-i", "mise"], { cwd: repoDir, home: homeDir, stdin: "ignore" });
+
+    expect(exitCode).toBe(0);
+    expect(existsSync(marker)).toBe(true);
+  });
+
+  test("the checklist without a terminal to fall back to fails clearly", async () => {
+    // setsid drops the controlling terminal, so /dev/tty cannot be opened.
+    const setsid = Bun.which("setsid");
+    if (!setsid) return;
+    const marker = join(repoDir, "mise-installed");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[mise]
+install.any = "touch ${marker}"
+`);
+
+    for (const stdin of [new Blob(["mise\n"]), "ignore" as const]) {
+      const { exitCode, stderr } = await runDot([], { cwd: repoDir, home: homeDir, stdin, launcher: [setsid] });
+
+      expect(exitCode).toBe(1);
+      expect(stderr).toContain("Interactive mode requires a terminal");
+    }
+    expect(existsSync(marker)).toBe(false);
+  });
+
   test("named install runs the full component lifecycle", async () => {
     const installMarker = join(repoDir, "installed");
     const postInstallMarker = join(repoDir, "postinstalled");
@@ -126,6 +210,755 @@ postlink = "touch ${postLinkMarker}"
     }
   });
 
+  test("named install skips the install command when check passes", async () => {
+    const presentMarker = join(repoDir, "present-installed");
+    const missingMarker = join(repoDir, "missing-installed");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[present]
+install.any = "touch ${presentMarker}"
+check = "exit 0"
+
+[missing]
+install.any = "touch ${missingMarker}"
+check = "exit 1"
+`);
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+
+    try {
+      process.argv = ["dot", "-i", "present", "-i", "missing"];
+      process.chdir(repoDir);
+
+      await main();
+
+      expect(existsSync(presentMarker)).toBe(false);
+      expect(existsSync(missingMarker)).toBe(true);
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+    }
+  });
+
+  test("uninstall picks the first command whose binary exists, such as sh", async () => {
+    const cache = join(homeDir, ".cache", "foo");
+    mkdirSync(cache, { recursive: true });
+    writeFileSync(join(repoDir, "dot.toml"), `
+[foo]
+uninstall.nonexistentmgrxyz = "nonexistentmgrxyz remove foo"
+uninstall.sh = "rm -rf ${cache}"
+`);
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+
+    try {
+      process.argv = ["dot", "-u", "foo", "--yes"];
+      process.chdir(repoDir);
+
+      await main();
+
+      expect(existsSync(cache)).toBe(false);
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+    }
+  });
+
+  test("purge runs the uninstall command and removes links", async () => {
+    const uninstallMarker = join(repoDir, "uninstalled");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+install.any = "true"
+uninstall.any = "touch ${uninstallMarker}"
+link."zshrc" = "~/.zshrc"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+    symlinkSync(join(repoDir, "zshrc"), join(homeDir, ".zshrc"));
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+
+    try {
+      process.argv = ["dot", "--purge", "zsh", "--yes"];
+      process.chdir(repoDir);
+
+      await main();
+
+      expect(existsSync(uninstallMarker)).toBe(true);
+      expect(existsSync(join(homeDir, ".zshrc"))).toBe(false);
+      expect(existsSync(join(repoDir, "zshrc"))).toBe(true);
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+    }
+  });
+
+  test("preuninstall runs before links are removed", async () => {
+    const log = join(repoDir, "pre.log");
+    const link = join(homeDir, ".zshrc");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+preuninstall = "test -L ${link} && echo link-present > ${log}"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+    symlinkSync(join(repoDir, "zshrc"), link);
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+
+    try {
+      process.argv = ["dot", "--purge", "zsh", "--yes"];
+      process.chdir(repoDir);
+
+      await main();
+
+      expect(readFileSync(log, "utf-8").trim()).toBe("link-present");
+      expect(existsSync(link)).toBe(false);
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+    }
+  });
+
+  test("a failing preuninstall stops the purge unless --force-uninstall", async () => {
+    const link = join(homeDir, ".zshrc");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+preuninstall = "false"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+    symlinkSync(join(repoDir, "zshrc"), link);
+
+    const refused = await runDot(["--purge", "zsh", "--yes"], { cwd: repoDir, home: homeDir });
+    expect(refused.exitCode).toBe(1);
+    expect(existsSync(link)).toBe(true);
+
+    const forced = await runDot(["--purge", "zsh", "--yes", "--force-uninstall"], { cwd: repoDir, home: homeDir });
+    expect(forced.exitCode).toBe(0);
+    expect(existsSync(link)).toBe(false);
+  });
+
+  test("a run_last component installs after the others, whatever the -i order", async () => {
+    const log = join(repoDir, "order.log");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[finalize]
+install.any = "echo finalize >> ${log}"
+run_last = true
+
+[zsh]
+install.any = "echo zsh >> ${log}"
+
+[git]
+install.any = "echo git >> ${log}"
+`);
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+
+    try {
+      process.argv = ["dot", "-i", "finalize", "-i", "zsh", "-i", "git"];
+      process.chdir(repoDir);
+
+      await main();
+
+      expect(readFileSync(log, "utf-8").trim().split("\n")).toEqual(["zsh", "git", "finalize"]);
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+    }
+  });
+
+  test("--batch-installs keeps run_first components ahead of the batch", async () => {
+    const log = join(repoDir, "pm.log");
+    const pm = join(repoDir, "pm");
+    writeFileSync(pm, `#!/bin/sh\necho "$*" >> ${log}\n`);
+    chmodSync(pm, 0o755);
+    writeFileSync(join(repoDir, "dot.toml"), `
+[a]
+install.any = "${pm} install a"
+
+[b]
+install.any = "${pm} install b"
+
+[setup]
+install.any = "${pm} install setup"
+run_first = true
+`);
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+
+    try {
+      process.argv = ["dot", "-i", "a", "-i", "b", "-i", "setup", "--batch-installs"];
+      process.chdir(repoDir);
+
+      await main();
+
+      expect(readFileSync(log, "utf-8").trim().split("\n")).toEqual(["install setup", "install a b"]);
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+    }
+  });
+
+  test("postrun runs once after a run that changed something", async () => {
+    const log = join(repoDir, "postrun.log");
+    writeFileSync(join(repoDir, "dot.toml"), `
+postrun = "echo ran >> ${log}"
+
+[zsh]
+install.any = "true"
+
+[git]
+install.any = "true"
+`);
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+
+    try {
+      process.argv = ["dot", "-i", "zsh", "-i", "git"];
+      process.chdir(repoDir);
+
+      await main();
+
+      expect(readFileSync(log, "utf8")).toBe("ran\n");
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+    }
+  });
+
+  test("postrun is skipped when nothing changed unless postrun_always is set", async () => {
+    const log = join(repoDir, "postrun.log");
+    const toml = (always: boolean) => `
+postrun = "echo ran >> ${log}"
+postrun_always = ${always}
+
+[zsh]
+install.any = "true"
+check = "sh"
+`;
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+
+    try {
+      process.argv = ["dot", "-i", "zsh"];
+      process.chdir(repoDir);
+
+      writeFileSync(join(repoDir, "dot.toml"), toml(false));
+      await main();
+      expect(existsSync(log)).toBe(false);
+
+      writeFileSync(join(repoDir, "dot.toml"), toml(true));
+      await main();
+      expect(readFileSync(log, "utf8")).toBe("ran\n");
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+    }
+  });
+
+  describe("--changed-exit", () => {
+    test("exits 0 when nothing changed", async () => {
+      writeFileSync(join(repoDir, "dot.toml"), `[zsh]\ninstall.any = "true"\ncheck = "sh"\n`);
+      expect((await runDot(["-i", "zsh", "--changed-exit"], { cwd: repoDir, home: homeDir })).exitCode).toBe(0);
+    });
+
+    test("exits 10 when changes were applied", async () => {
+      writeFileSync(join(repoDir, "dot.toml"), `[zsh]\ninstall.any = "true"\n`);
+      expect((await runDot(["-i", "zsh", "--changed-exit"], { cwd: repoDir, home: homeDir })).exitCode).toBe(10);
+    });
+
+    test("exits 2 on failure", async () => {
+      writeFileSync(join(repoDir, "dot.toml"), `[zsh]\ninstall.any = "false"\n`);
+      expect((await runDot(["-i", "zsh", "--changed-exit"], { cwd: repoDir, home: homeDir })).exitCode).toBe(2);
+    });
+
+    test("exits 2 when the run dies with a fatal error", async () => {
+      writeFileSync(join(repoDir, "dot.toml"), `[zsh]\ninstall.any = "true"\n`);
+      const report = join(repoDir, "missing", "run.md");
+      const { exitCode, stderr } = await runDot(["-i", "zsh", "--changed-exit", "--report", report], { cwd: repoDir, home: homeDir });
+
+      expect(exitCode).toBe(2);
+      expect(stderr).toContain("Fatal:");
+    });
+  });
+
+  test("dry-run purge reports the uninstall plan without touching files", async () => {
+    const uninstallMarker = join(repoDir, "uninstalled");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+install.any = "true"
+uninstall.any = "touch ${uninstallMarker}"
+link."zshrc" = "~/.zshrc"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+    symlinkSync(join(repoDir, "zshrc"), join(homeDir, ".zshrc"));
+
+    const { exitCode, stdout } = await runDot(["--dry-run", "--purge", "zsh"], { cwd: repoDir, home: homeDir });
+    const plainOutput = stripAnsi(stdout);
+
+    expect(exitCode).toBe(0);
+    expect(plainOutput).toContain(`would uninstall zsh: touch ${uninstallMarker}`);
+    expect(plainOutput).toContain(`would remove link ${join(homeDir, ".zshrc")}`);
+    expect(plainOutput).toContain("Uninstall plan (dry run):");
+    expect(plainOutput).toContain(`remove link: ${join(homeDir, ".zshrc")}`);
+    expect(existsSync(uninstallMarker)).toBe(false);
+    expect(readlinkSync(join(homeDir, ".zshrc"))).toBe(join(repoDir, "zshrc"));
+  });
+
+  test("purge without --yes and without a terminal refuses, even with input piped in", async () => {
+    // setsid drops the controlling terminal, so /dev/tty cannot be opened.
+    const setsid = Bun.which("setsid");
+    if (!setsid) return;
+    const link = join(homeDir, ".zshrc");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+    symlinkSync(join(repoDir, "zshrc"), link);
+
+    const { exitCode, stderr } = await runDot(["--purge", "zsh"], { cwd: repoDir, home: homeDir, stdin: new Blob(["y\n"]), launcher: [setsid] });
+
+    expect(exitCode).toBe(1);
+    expect(stderr).toContain("Pass --yes");
+    expect(readlinkSync(link)).toBe(join(repoDir, "zshrc"));
+  });
+
+  test("--snapshot then --apply sets up the same components in a fresh home", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+
+[git]
+link."gitconfig" = "~/.gitconfig"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+    writeFileSync(join(repoDir, "gitconfig"), "# git config");
+    symlinkSync(join(repoDir, "zshrc"), join(homeDir, ".zshrc"));
+
+    const snapshot = join(repoDir, "components.yaml");
+    expect((await runDot(["--snapshot", snapshot], { cwd: repoDir, home: homeDir })).exitCode).toBe(0);
+    expect(readFileSync(snapshot, "utf-8")).toContain("components:\n  - zsh\n");
+
+    const freshHome = makeTempDir();
+    try {
+      expect((await runDot(["--apply", snapshot], { cwd: repoDir, home: freshHome })).exitCode).toBe(0);
+      expect(readlinkSync(join(freshHome, ".zshrc"))).toBe(join(repoDir, "zshrc"));
+      expect(existsSync(join(freshHome, ".gitconfig"))).toBe(false);
+    } finally {
+      rmSync(freshHome, { recursive: true, force: true });
+    }
+  });
+
+  test("--apply installs exactly the listed names, not fuzzy matches", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[git]
+link."gitconfig" = "~/.gitconfig"
+
+[gitui]
+link."gitui" = "~/.gitui"
+
+[lazygit]
+link."lazygit" = "~/.lazygit"
+`);
+    for (const f of ["gitconfig", "gitui", "lazygit"]) writeFileSync(join(repoDir, f), `# ${f}`);
+    writeFileSync(join(repoDir, "components.yaml"), "components:\n  - git\n");
+
+    const { exitCode } = await runDot(["--apply", "components.yaml"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(0);
+    expect(readlinkSync(join(homeDir, ".gitconfig"))).toBe(join(repoDir, "gitconfig"));
+    expect(existsSync(join(homeDir, ".gitui"))).toBe(false);
+    expect(existsSync(join(homeDir, ".lazygit"))).toBe(false);
+  });
+
+  test("--components-from-git-diff installs only components whose sources changed", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[git]
+link."git/config" = "~/.gitconfig"
+
+[gitui]
+link."gitui/theme.ron" = "~/.gitui-theme.ron"
+`);
+    mkdirSync(join(repoDir, "git"));
+    mkdirSync(join(repoDir, "gitui"));
+    writeFileSync(join(repoDir, "git", "config"), "[user]\n");
+    writeFileSync(join(repoDir, "gitui", "theme.ron"), "()\n");
+    git(repoDir, "init", "--quiet", "--initial-branch=main");
+    git(repoDir, "add", ".");
+    git(repoDir, "commit", "--quiet", "-m", "one");
+    writeFileSync(join(repoDir, "git", "config"), "[user]\n  name = me\n");
+
+    const { exitCode } = await runDot(["--components-from-git-diff", "HEAD"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(0);
+    expect(readlinkSync(join(homeDir, ".gitconfig"))).toBe(join(repoDir, "git", "config"));
+    expect(existsSync(join(homeDir, ".gitui-theme.ron"))).toBe(false);
+  });
+
+  test("--components-from-git-diff picks up untracked sources and edited dot.toml sections", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+
+[vim]
+link."vimrc" = "~/.vimrc"
+
+[tmux]
+link."tmux.conf" = "~/.tmux.conf"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh\n");
+    writeFileSync(join(repoDir, "vimrc"), "\" vim\n");
+    writeFileSync(join(repoDir, "tmux.conf"), "# tmux\n");
+    git(repoDir, "init", "--quiet", "--initial-branch=main");
+    git(repoDir, "add", ".");
+    git(repoDir, "commit", "--quiet", "-m", "one");
+    writeFileSync(join(repoDir, "dot.toml"), `disabled_managers = ["snap"]
+
+[zsh]
+link."zshrc" = "~/.zshrc"
+
+[vim]
+link."vimrc" = "~/.vimrc"
+
+[tmux]
+link."tmux.conf" = "~/.tmux.conf"
+link."tmux-local.conf" = "~/.tmux-local.conf"
+
+[git]
+link."gitconfig" = "~/.gitconfig"
+`);
+    writeFileSync(join(repoDir, "tmux-local.conf"), "# local\n");
+    writeFileSync(join(repoDir, "gitconfig"), "[user]\n");
+
+    const { exitCode, stderr } = await runDot(["--components-from-git-diff", "HEAD"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(0);
+    expect(readlinkSync(join(homeDir, ".tmux.conf"))).toBe(join(repoDir, "tmux.conf"));
+    expect(readlinkSync(join(homeDir, ".gitconfig"))).toBe(join(repoDir, "gitconfig"));
+    expect(existsSync(join(homeDir, ".zshrc"))).toBe(false);
+    expect(existsSync(join(homeDir, ".vimrc"))).toBe(false);
+    expect(stderr).toContain("dot.toml settings changed since HEAD (disabled_managers)");
+  });
+
+  test("--apply refuses a snapshot with unknown components", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+install.any = "true"
+`);
+    writeFileSync(join(repoDir, "components.yaml"), "components:\n  - zsh\n  - fish\n");
+
+    const { exitCode, stderr } = await runDot(["--apply", "components.yaml"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(1);
+    expect(stderr).toContain("not in dot.toml for this OS: fish");
+  });
+
+  test("--audit-file appends one JSON line per run", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+
+[broken]
+install.any = "false"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+    const auditFile = join(homeDir, "audit", "dot.jsonl");
+
+    for (const expected of [0, 1]) {
+      const { exitCode } = await runDot(["-i", "zsh", "-i", "broken", "--audit-file", auditFile], { cwd: repoDir, home: homeDir });
+      expect(exitCode).toBe(1);
+      expect(readFileSync(auditFile, "utf-8").trim().split("\n")).toHaveLength(expected + 1);
+    }
+
+    const [first, second] = readFileSync(auditFile, "utf-8").trim().split("\n").map((l) => JSON.parse(l));
+    expect(Object.keys(first).sort()).toEqual(["changed", "components", "failed", "hostname", "timestamp", "version"]);
+    expect(first.components).toEqual(["zsh", "broken"]);
+    expect(first.changed).toBe(1);
+    expect(first.failed).toBe(1);
+    expect(second.changed).toBe(0);
+  });
+
+  test("rollback after --adopt puts the adopted file back", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[vim]
+link."vimrc" = "~/.vimrc"
+postinstall = "false"
+`);
+    writeFileSync(join(repoDir, "vimrc"), "set number\n");
+    writeFileSync(join(homeDir, ".vimrc"), "set number\n");
+
+    const { exitCode } = await runDot(["-i", "vim", "--adopt", "--rollback-on-failure"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(1);
+    expect(lstatSync(join(homeDir, ".vimrc")).isSymbolicLink()).toBe(false);
+    expect(readFileSync(join(homeDir, ".vimrc"), "utf-8")).toBe("set number\n");
+  });
+
+  test("--report writes the run report even when a component fails", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+install.any = "true"
+
+[broken]
+install.any = "false"
+`);
+    const report = join(repoDir, "report.md");
+
+    const { exitCode } = await runDot(["-i", "zsh", "-i", "broken", "--report", "report.md"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(1);
+    const md = readFileSync(report, "utf8");
+    expect(md).toContain("| zsh | installed | `true` |");
+    expect(md).toContain("| broken | failed | `false` |");
+  });
+
+  test("--fail-fast stops after the first failed component", async () => {
+    const installMarker = join(repoDir, "installed");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[broken]
+install.any = "false"
+
+[zsh]
+install.any = "touch ${installMarker}"
+`);
+
+    const { exitCode, stderr } = await runDot(["-i", "broken", "-i", "zsh", "--fail-fast"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(1);
+    expect(existsSync(installMarker)).toBe(false);
+    expect(stderr).toContain("not attempted after failure: zsh");
+  });
+
+  test("--simulate-os resolves components for another OS without running them", async () => {
+    const installMarker = join(repoDir, "installed");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[native]
+os = ["${process.platform === "darwin" ? "mac" : "linux"}"]
+install.any = "true"
+
+[winonly]
+os = ["windows"]
+install.any = "touch ${installMarker}"
+`);
+
+    const list = await runDot(["--simulate-os", "windows", "--list"], { cwd: repoDir, home: homeDir });
+    expect(list.exitCode).toBe(0);
+    expect(stripAnsi(list.stdout)).toContain("winonly");
+    expect(stripAnsi(list.stdout)).not.toContain("native");
+
+    const install = await runDot(["--simulate-os", "windows", "-i", "winonly"], { cwd: repoDir, home: homeDir });
+    expect(install.exitCode).toBe(0);
+    expect(stripAnsi(install.stdout)).toContain("[dry-run]");
+    expect(existsSync(installMarker)).toBe(false);
+  });
+
+  test("rollback removes links when postinstall fails", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+postinstall = "false"
+rollback = true
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+
+    const { exitCode, stdout: output } = await runDot(["-i", "zsh"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(1);
+    expect(output).toContain("removed");
+    expect(existsSync(join(homeDir, ".zshrc"))).toBe(false);
+  });
+
+  test("rollback = true also holds in the interactive checklist", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+postinstall = "false"
+rollback = true
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+    const originalIsTty = Object.getOwnPropertyDescriptor(process.stdin, "isTTY");
+
+    try {
+      process.argv = ["dot"];
+      process.chdir(repoDir);
+      Object.defineProperty(process.stdin, "isTTY", { value: true, configurable: true });
+      prompts.inject([["zsh"]]);
+
+      await main();
+
+      expect(existsSync(join(homeDir, ".zshrc"))).toBe(false);
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+      if (originalIsTty) {
+        Object.defineProperty(process.stdin, "isTTY", originalIsTty);
+      } else {
+        delete (process.stdin as any).isTTY;
+      }
+    }
+  });
+
+  test("--gc runs alongside other actions", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+    const backup = join(homeDir, ".zshrc.dot.bak");
+    writeFileSync(backup, "old");
+    const old = new Date(Date.now() - 40 * 24 * 60 * 60 * 1000);
+    utimesSync(backup, old, old);
+
+    const { exitCode } = await runDot(["--gc", "-i", "zsh"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(0);
+    expect(existsSync(backup)).toBe(false);
+    expect(readlinkSync(join(homeDir, ".zshrc"))).toBe(join(repoDir, "zshrc"));
+  });
+
+  test("--list --json prints the resolved inventory", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[shell]
+description = "POSIX shell"
+install.any = "true"
+check = "sh"
+postinstall = "true"
+
+[zsh]
+link."zshrc" = "~/.zshrc"
+`);
+
+    const { exitCode, stdout } = await runDot(["--list", "--json"], { cwd: repoDir, home: homeDir });
+    const inventory = JSON.parse(stdout);
+
+    expect(exitCode).toBe(0);
+    expect(inventory).toHaveLength(2);
+    expect(inventory[0]).toMatchObject({
+      name: "shell",
+      description: "POSIX shell",
+      manager: "any",
+      maxAttempts: 1,
+      installed: true,
+      actions: { install: true, link: false, postinstall: true },
+    });
+    expect(inventory[1]).toMatchObject({
+      name: "zsh",
+      installed: false,
+      linked: false,
+      links: { "zshrc": ["~/.zshrc"] },
+      actions: { install: false, link: true },
+    });
+  });
+
+  test("--list-links prints managed links and --broken flags the bad ones", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[zsh]
+link."zshrc" = "~/.zshrc"
+
+[git]
+link."gitconfig" = "~/.gitconfig"
+`);
+    writeFileSync(join(repoDir, "zshrc"), "# zsh");
+    writeFileSync(join(repoDir, "gitconfig"), "[user]");
+    symlinkSync(join(repoDir, "zshrc"), join(homeDir, ".zshrc"));
+    symlinkSync(join(homeDir, "elsewhere"), join(homeDir, ".gitconfig"));
+
+    const listed = await runDot(["--list-links"], { cwd: repoDir, home: homeDir });
+    expect(listed.exitCode).toBe(0);
+    const all = stripAnsi(listed.stdout);
+    expect(all).toContain(`${join(homeDir, ".zshrc")} -> ${join(repoDir, "zshrc")}`);
+    expect(all).toContain(`${join(homeDir, ".gitconfig")} -> ${join(repoDir, "gitconfig")} [elsewhere]`);
+
+    const flagged = await runDot(["--list-links", "--broken"], { cwd: repoDir, home: homeDir });
+    expect(flagged.exitCode).toBe(0);
+    const broken = stripAnsi(flagged.stdout);
+    expect(broken).not.toContain(".zshrc");
+    expect(broken).toContain(".gitconfig");
+    expect(broken).toContain("[elsewhere]");
+  });
+
+  test("hooks see whether install ran and links changed", async () => {
+    const out = join(repoDir, "hook.out");
+    const toml = (check: string) => `
+[zsh]
+install.any = "true"
+${check}
+link."zshrc" = "~/.zshrc"
+postlink = "echo $DOT_INSTALL_RAN $DOT_LINK_CHANGED > ${out}"
+`;
+    writeFileSync(join(repoDir, "zshrc"), "# zsh config");
+
+    const originalArgv = process.argv;
+    const originalCwd = process.cwd();
+
+    try {
+      process.argv = ["dot", "-i", "zsh"];
+      process.chdir(repoDir);
+
+      writeFileSync(join(repoDir, "dot.toml"), toml(""));
+      await main();
+      expect(readFileSync(out, "utf8").trim()).toBe("true true");
+
+      writeFileSync(join(repoDir, "dot.toml"), toml(`check = "sh"`));
+      await main();
+      expect(readFileSync(out, "utf8").trim()).toBe("false false");
+    } finally {
+      process.argv = originalArgv;
+      process.chdir(originalCwd);
+    }
+  });
+
+  test("Ctrl-C restores the terminal and exits non-zero", async () => {
+    if (process.platform === "win32") return;
+    const installMarker = join(repoDir, "installed");
+    writeFileSync(join(repoDir, "dot.toml"), `
+[slow]
+install.any = "sleep 2"
+
+[zsh]
+install.any = "touch ${installMarker}"
+`);
+
+    const { exitCode, stderr } = await runDot(["-i", "slow", "-i", "zsh"], { cwd: repoDir, home: homeDir, interruptAfter: 500 });
+
+    expect(exitCode).toBe(130);
+    expect(stderr).toContain("interrupted");
+    expect(existsSync(installMarker)).toBe(false);
+  });
+
+  test("--only-restricted keeps only components restricted to this OS", async () => {
+    const here = process.platform === "darwin" ? "mac" : process.platform === "win32" ? "windows" : "linux";
+    writeFileSync(join(repoDir, "dot.toml"), `
+[universal]
+install.any = "true"
+
+[native]
+os = ["${here}"]
+install.any = "true"
+
+[elsewhere]
+os = ["${here === "windows" ? "linux" : "windows"}"]
+install.any = "true"
+`);
+
+    const { exitCode, stdout } = await runDot(["--list", "--json", "--only-restricted"], { cwd: repoDir, home: homeDir });
+    const inventory = JSON.parse(stdout);
+
+    expect(exitCode).toBe(0);
+    expect(inventory.map((c: { name: string }) => c.name)).toEqual(["native"]);
+  });
+
   test("direct commands show completed lifecycle steps", async () => {
     const installMarker = join(repoDir, "installed");
     writeFileSync(join(repoDir, "dot.toml"), `
@@ -138,16 +971,10 @@ postlink = "true"
     mkdirSync(join(repoDir, "config"));
     writeFileSync(join(repoDir, "config/mise.toml"), "# mise config");
 
-    const child = Bun.spawn([process.execPath, join(import.meta.dir, "../src/index.ts"), "-i", "mise"], {
-      cwd: repoDir,
-      env: { ...process.env, HOME: homeDir },
-      stdout: "pipe",
-      stderr: "pipe",
-    });
-    const output = await new Response(child.stdout).text();
-    const plainOutput = output.replace(/\x1B\[[0-?]*[ -/]*[@-~]/g, "");
+    const { exitCode, stdout } = await runDot(["-i", "mise"], { cwd: repoDir, home: homeDir });
+    const plainOutput = stripAnsi(stdout);
 
-    expect(await child.exited).toBe(0);
+    expect(exitCode).toBe(0);
     expect(plainOutput).toContain("mise");
     expect(plainOutput).toContain("✓ installed");
     expect(plainOutput).toContain("✓ linked");
@@ -156,6 +983,46 @@ postlink = "true"
     expect(plainOutput).toContain("✓ Done.");
   });
 
+  test("direct commands title components with their description", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[nvim]
+install.any = "true"
+description = "Neovim + plugins"
+`);
+
+    const { exitCode, stdout: output } = await runDot(["-i", "nvim"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(0);
+    expect(output).toContain("Neovim + plugins");
+  });
+
+  test("list aligns names and puts unusable components last", async () => {
+    writeFileSync(join(repoDir, "dot.toml"), `
+[nothing-can-install-this-component]
+install.nonexistentmanagerxyz = "nonexistentmanagerxyz install it"
+
+[git]
+install.any = "echo git"
+
+[a-much-longer-component-name]
+install.any = "echo long"
+`);
+
+    const { exitCode, stdout: output } = await runDot(["--list"], { cwd: repoDir, home: homeDir });
+
+    expect(exitCode).toBe(0);
+    expect(output).not.toContain("\x1b[");
+
+    const rows = output.split("\n").filter((line) => line.includes("["));
+    expect(rows.map((line) => line.trim().split(" ")[0])).toEqual([
+      "git",
+      "a-much-longer-component-name",
+      "nothing-can-install-this-component",
+    ]);
+    const columns = new Set(rows.map((line) => line.indexOf("[")));
+    expect(columns.size).toBe(1);
+  });
+
   test("dry run does not create links", async () => {
     const configToml = `
 [zsh]
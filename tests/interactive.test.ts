@@ -1,6 +1,6 @@
 import { describe, test, expect } from "bun:test";
-import { buildChecklist, CheckboxItem } from "../src/interactive";
-import { ResolvedComponent } from "../src/config";
+import { buildChecklist, CheckboxItem, confirmOnTerminal } from "../src/interactive";
+import { ResolvedComponent, resolveComponents } from "../src/config";
 
 function makeComponent(overrides: Partial<ResolvedComponent> = {}): ResolvedComponent {
   return {
@@ -9,13 +9,16 @@ function makeComponent(overrides: Partial<ResolvedComponent> = {}): ResolvedComp
     uninstall: {},
     link: {},
     defaults: {},
+    defaultsSet: {},
     availableManager: "brew",
     installCommand: "brew install zsh",
+    uninstallCommand: null,
     hasDefaults: false,
     hasLinks: false,
     hasInstall: true,
     allLinksDone: false,
     isInstalled: false,
+    skipReason: null,
     ...overrides,
   };
 }
@@ -44,9 +47,38 @@ describe("buildChecklist", () => {
     expect(items[0].unavailable).toBe(true);
   });
 
+  test("a component with only defaults_set is available", () => {
+    const resolved = resolveComponents({
+      components: [{
+        name: "dock",
+        install: {},
+        uninstall: {},
+        link: {},
+        defaults: {},
+        defaultsSet: { "com.apple.dock autohide": true },
+      }],
+    }, "mac");
+    const items = buildChecklist(resolved);
+    expect(items[0].unavailable).toBe(false);
+    expect(items[0].hasDefaults).toBe(true);
+  });
+
   test("available items are not unavailable", () => {
     const comps = [makeComponent({ availableManager: "brew" })];
     const items = buildChecklist(comps);
     expect(items[0].unavailable).toBe(false);
   });
 });
+
+describe("confirmOnTerminal", () => {
+  test("gives no answer when stdin is piped and no terminal can be opened", async () => {
+    let opened = false;
+    const answer = await confirmOnTerminal("Uninstall these components?", false, false, () => {
+      opened = true;
+      return null;
+    });
+
+    expect(opened).toBe(true);
+    expect(answer).toBeNull();
+  });
+});
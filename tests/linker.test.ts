@@ -1,7 +1,7 @@
 import { describe, test, expect, beforeEach, afterEach } from "bun:test";
-import { createLinks, removeLinks, LinkResult } from "../src/linker";
+import { createLinks, removeLinks, rollbackLinks, linkError, allLinksCorrect, LinkResult } from "../src/linker";
 import { tmpdir } from "node:os";
-import { mkdtempSync, writeFileSync, symlinkSync, rmSync, existsSync, readlinkSync } from "node:fs";
+import { mkdtempSync, writeFileSync, symlinkSync, rmSync, existsSync, readlinkSync, mkdirSync, readFileSync } from "node:fs";
 import { join } from "node:path";
 
 function makeTempDir(): string {
@@ -84,6 +84,20 @@ describe("createLinks", () => {
     expect(readlinkSync(dest)).toBe(src);
   });
 
+  test("refuses to replace a populated directory", () => {
+    const src = join(tmp, "myapp");
+    mkdirSync(src);
+    const dest = join(home, ".config");
+    mkdirSync(dest);
+    writeFileSync(join(dest, "other-app.conf"), "keep me");
+
+    const results = createLinks("myapp", { "myapp": [dest] }, tmp, { dryRun: false, verbose: false, interactive: false });
+    expect(results[0].failed).toBe(true);
+    expect(results[0].reason).toContain("populated directory");
+    expect(readFileSync(join(dest, "other-app.conf"), "utf8")).toBe("keep me");
+    expect(existsSync(dest + ".dot.bak")).toBe(false);
+  });
+
   test("dry run does not create links", () => {
     const src = join(tmp, "zshrc");
     writeFileSync(src, "# zsh config");
@@ -94,6 +108,139 @@ describe("createLinks", () => {
     expect(existsSync(dest)).toBe(false);
   });
 
+  test("no-replace blocks instead of deleting a real file", () => {
+    writeFileSync(join(tmp, "zshrc"), "# repo config");
+    const dest = join(home, ".zshrc");
+    writeFileSync(dest, "# hand-written config");
+
+    const results = createLinks("zsh", { "zshrc": [dest] }, tmp, {
+      dryRun: false, verbose: false, interactive: false, noReplace: true,
+    });
+
+    expect(results[0].blocked).toBe(true);
+    expect(results[0].failed).toBe(false);
+    expect(readFileSync(dest, "utf-8")).toBe("# hand-written config");
+    expect(existsSync(dest + ".dot.bak")).toBe(false);
+  });
+
+  test("adopt links over an identical copy without a backup", () => {
+    const src = join(tmp, "vimrc");
+    writeFileSync(src, "set number\n");
+    const dest = join(home, ".vimrc");
+    writeFileSync(dest, "set number\n");
+
+    const results = createLinks("vim", { "vimrc": [dest] }, tmp, {
+      dryRun: false, verbose: false, interactive: false, adopt: true,
+    });
+
+    expect(results[0].adopted).toBe(true);
+    expect(readlinkSync(dest)).toBe(src);
+    expect(existsSync(dest + ".dot.bak")).toBe(false);
+  });
+
+  test("adopt still backs up a file that differs", () => {
+    const src = join(tmp, "vimrc");
+    writeFileSync(src, "set number\n");
+    const dest = join(home, ".vimrc");
+    writeFileSync(dest, "set nonumber\n");
+
+    const results = createLinks("vim", { "vimrc": [dest] }, tmp, {
+      dryRun: false, verbose: false, interactive: false, adopt: true,
+    });
+
+    expect(results[0].adopted).toBeUndefined();
+    expect(results[0].backedUp).toBe(true);
+    expect(readFileSync(dest + ".dot.bak", "utf-8")).toBe("set nonumber\n");
+  });
+
+  test("no-replace blocks a symlink that points elsewhere but keeps correct ones", () => {
+    const src = join(tmp, "zshrc");
+    writeFileSync(src, "# repo config");
+    const other = join(home, ".zshrc");
+    const correct = join(home, ".zshrc-correct");
+    symlinkSync(join(tmp, "somewhere-else"), other);
+    symlinkSync(src, correct);
+
+    const results = createLinks("zsh", { "zshrc": [other, correct] }, tmp, {
+      dryRun: false, verbose: false, interactive: false, noReplace: true,
+    });
+
+    expect(results[0].blocked).toBe(true);
+    expect(results[0].reason).toContain("somewhere-else");
+    expect(readlinkSync(other)).toBe(join(tmp, "somewhere-else"));
+    expect(results[1].success).toBe(true);
+    expect(results[1].blocked).toBeUndefined();
+  });
+
+  test("elevated dry run reports sudo without running it", () => {
+    writeFileSync(join(tmp, "hosts"), "127.0.0.1 localhost");
+    const dest = join(home, "etc", "hosts");
+
+    const writes: string[] = [];
+    const write = process.stdout.write;
+    process.stdout.write = ((chunk: string) => {
+      writes.push(chunk);
+      return true;
+    }) as typeof process.stdout.write;
+    try {
+      const results = createLinks("hosts", { "hosts": [dest] }, tmp, {
+        dryRun: true, verbose: false, interactive: false, report: true, elevated: true,
+      });
+      expect(results[0].dryRun).toBe(true);
+    } finally {
+      process.stdout.write = write;
+    }
+
+    expect(writes.join("")).toContain(`would link hosts → ${dest} (sudo)`);
+    expect(existsSync(dest)).toBe(false);
+  });
+
+  test("elevated links never replace an existing file", () => {
+    writeFileSync(join(tmp, "hosts"), "127.0.0.1 localhost");
+    const dest = join(home, "hosts");
+    writeFileSync(dest, "original");
+
+    const results = createLinks("hosts", { "hosts": [dest] }, tmp, {
+      dryRun: false, verbose: false, interactive: false, elevated: true,
+    });
+
+    expect(results[0].failed).toBe(true);
+    expect(results[0].reason).toContain("not a symlink");
+    expect(readFileSync(dest, "utf-8")).toBe("original");
+  });
+
+  test("elevated rollback goes through sudo and puts back the replaced symlink", () => {
+    // A stand-in sudo that logs its arguments and runs them unprivileged.
+    const bin = join(tmp, "bin");
+    mkdirSync(bin);
+    const log = join(tmp, "sudo.log");
+    writeFileSync(join(bin, "sudo"), `#!/bin/sh\necho "$*" >> ${log}\nexec "$@"\n`, { mode: 0o755 });
+    writeFileSync(join(tmp, "hosts"), "127.0.0.1 localhost");
+    const elsewhere = join(tmp, "other-hosts");
+    writeFileSync(elsewhere, "# someone else's hosts");
+    const dest = join(home, "hosts");
+    symlinkSync(elsewhere, dest);
+    const options = { dryRun: false, verbose: false, interactive: false, elevated: true };
+
+    const originalPath = process.env.PATH;
+    process.env.PATH = `${bin}:${originalPath}`;
+    try {
+      const results = createLinks("hosts", { "hosts": [dest] }, tmp, options);
+      expect(results[0].success).toBe(true);
+      expect(results[0].replacedLink).toBe(elsewhere);
+      expect(readlinkSync(dest)).toBe(join(tmp, "hosts"));
+
+      rollbackLinks("hosts", results, options);
+    } finally {
+      process.env.PATH = originalPath;
+    }
+
+    expect(readlinkSync(dest)).toBe(elsewhere);
+    expect(readFileSync(log, "utf8")).toBe(
+      `ln -sfn ${join(tmp, "hosts")} ${dest}\nrm ${dest}\nln -sfn ${elsewhere} ${dest}\n`
+    );
+  });
+
   test("reports missing source", () => {
     const dest = join(home, ".zshrc");
     const results = createLinks("zsh", { "nonexistent": [dest] }, tmp, { dryRun: false, verbose: false, interactive: false });
@@ -101,6 +248,131 @@ describe("createLinks", () => {
     expect(results[0].reason).toContain("not found");
   });
 
+  test("allowMissingSource skips a missing source instead of failing", () => {
+    const dest = join(home, ".zshrc");
+    const results = createLinks("zsh", { "generated": [dest] }, tmp, {
+      dryRun: false,
+      verbose: false,
+      interactive: false,
+      allowMissingSource: true,
+    });
+    expect(results[0].failed).toBe(false);
+    expect(results[0].skipped).toBe(true);
+    expect(results[0].reason).toContain("not found");
+    expect(existsSync(dest)).toBe(false);
+  });
+
+  test("rollbackLinks removes new links and restores backups", () => {
+    writeFileSync(join(tmp, "zshrc"), "# zsh config");
+    writeFileSync(join(tmp, "gitconfig"), "# git config");
+    writeFileSync(join(home, ".gitconfig"), "original");
+    const options = { dryRun: false, verbose: false, interactive: false };
+
+    const results = createLinks("dots", { "zshrc": [join(home, ".zshrc")], "gitconfig": [join(home, ".gitconfig")] }, tmp, options);
+    expect(results.map((r) => r.backedUp)).toEqual([false, true]);
+
+    rollbackLinks("dots", results, options);
+    expect(existsSync(join(home, ".zshrc"))).toBe(false);
+    expect(readFileSync(join(home, ".gitconfig"), "utf8")).toBe("original");
+    expect(existsSync(join(home, ".gitconfig.dot.bak"))).toBe(false);
+  });
+
+  test("an unknown ~user fails only that link", () => {
+    writeFileSync(join(tmp, "profile"), "# profile");
+    writeFileSync(join(tmp, "zshrc"), "# zsh config");
+    const results = createLinks(
+      "work",
+      { "profile": ["~dot-no-such-user/.profile"], "zshrc": [join(home, ".zshrc")] },
+      tmp,
+      { dryRun: false, verbose: false, interactive: false }
+    );
+    expect(results[0].failed).toBe(true);
+    expect(results[0].reason).toContain("unknown user in path: ~dot-no-such-user");
+    expect(results[1].success).toBe(true);
+  });
+
+  test("rollbackLinks puts back a symlink it replaced", () => {
+    writeFileSync(join(tmp, "zshrc"), "# zsh config");
+    const elsewhere = join(tmp, "other-zshrc");
+    writeFileSync(elsewhere, "# someone else's config");
+    const dest = join(home, ".zshrc");
+    symlinkSync(elsewhere, dest);
+    const options = { dryRun: false, verbose: false, interactive: false };
+
+    const results = createLinks("zsh", { "zshrc": [dest] }, tmp, options);
+    expect(results[0].replacedLink).toBe(elsewhere);
+
+    rollbackLinks("zsh", results, options);
+    expect(readlinkSync(dest)).toBe(elsewhere);
+  });
+
+  test("reports the parent directories it had to create", () => {
+    writeFileSync(join(tmp, "config.toml"), "# foo");
+    mkdirSync(join(home, ".config"));
+    const dest = join(home, ".config", "foo", "conf.d", "config.toml");
+
+    const writes: string[] = [];
+    const write = process.stdout.write;
+    process.stdout.write = ((chunk: string) => {
+      writes.push(chunk);
+      return true;
+    }) as typeof process.stdout.write;
+    let results: LinkResult[] = [];
+    try {
+      results = createLinks("foo", { "config.toml": [dest] }, tmp, { dryRun: false, verbose: true, interactive: false });
+    } finally {
+      process.stdout.write = write;
+    }
+
+    const created = [join(home, ".config", "foo"), join(home, ".config", "foo", "conf.d")];
+    expect(results[0].createdDirs).toEqual(created);
+    expect(writes.join("")).toContain(`created directory ${created[0]}`);
+
+    rollbackLinks("foo", results, { dryRun: false, verbose: false, interactive: false });
+    expect(existsSync(created[0])).toBe(false);
+    expect(existsSync(join(home, ".config"))).toBe(true);
+  });
+
+  test("relinks a dangling symlink left by a moved repo", () => {
+    const src = join(tmp, "zshrc");
+    writeFileSync(src, "# zsh config");
+    const dest = join(home, ".zshrc");
+    symlinkSync(join(tmp, "old-repo", "zshrc"), dest);
+
+    const writes: string[] = [];
+    const write = process.stdout.write;
+    process.stdout.write = ((chunk: string) => {
+      writes.push(chunk);
+      return true;
+    }) as typeof process.stdout.write;
+    try {
+      const results = createLinks("zsh", { "zshrc": [dest] }, tmp, { dryRun: false, verbose: false, interactive: false, report: true });
+      expect(results[0].success).toBe(true);
+    } finally {
+      process.stdout.write = write;
+    }
+
+    expect(readlinkSync(dest)).toBe(src);
+    expect(writes.join("")).toContain(`relinking stale symlink from ${join(tmp, "old-repo", "zshrc")} to ${src}`);
+  });
+
+  test("target prefix redirects links under an alternate root", () => {
+    const src = join(tmp, "zshrc");
+    writeFileSync(src, "# zsh config");
+    const root = join(tmp, "rootfs");
+
+    const results = createLinks("zsh", { "zshrc": ["~/.zshrc"] }, tmp, {
+      dryRun: false,
+      verbose: false,
+      interactive: false,
+      targetPrefix: root,
+    });
+    expect(results[0].success).toBe(true);
+    expect(results[0].dest).toBe(join(root, home, ".zshrc"));
+    expect(readlinkSync(join(root, home, ".zshrc"))).toBe(src);
+    expect(existsSync(join(home, ".zshrc"))).toBe(false);
+  });
+
   test("creates parent directories for destination", () => {
     const src = join(tmp, "config");
     writeFileSync(src, "content");
@@ -110,6 +382,41 @@ describe("createLinks", () => {
     expect(results[0].success).toBe(true);
     expect(existsSync(dest)).toBe(true);
   });
+
+  test("a trailing-slash source links the directory itself", () => {
+    mkdirSync(join(tmp, "nvim"));
+    writeFileSync(join(tmp, "nvim", "init.lua"), "-- nvim");
+    const dest = join(home, ".config/nvim/");
+
+    const results = createLinks("nvim", { "nvim/": [dest] }, tmp, { dryRun: false, verbose: false, interactive: false });
+    expect(results[0].success).toBe(true);
+    expect(readlinkSync(join(home, ".config/nvim"))).toBe(join(tmp, "nvim"));
+
+    const again = createLinks("nvim", { "nvim/": [dest] }, tmp, { dryRun: false, verbose: false, interactive: false });
+    expect(again[0].skipped).toBe(true);
+  });
+
+  test("a trailing-slash source that is a file is an error", () => {
+    writeFileSync(join(tmp, "gitconfig"), "[user]");
+    const dest = join(home, ".gitconfig");
+
+    const errors: string[] = [];
+    const write = process.stderr.write;
+    process.stderr.write = ((chunk: string) => {
+      errors.push(chunk);
+      return true;
+    }) as typeof process.stderr.write;
+    let results: LinkResult[];
+    try {
+      results = createLinks("git", { "gitconfig/": [dest] }, tmp, { dryRun: false, verbose: false, interactive: false });
+    } finally {
+      process.stderr.write = write;
+    }
+    expect(results[0].failed).toBe(true);
+    expect(results[0].reason).toBe('source gitconfig/ ends with "/" but is not a directory');
+    expect(errors).toEqual([]);
+    expect(existsSync(dest)).toBe(false);
+  });
 });
 
 describe("removeLinks", () => {
@@ -163,4 +470,62 @@ describe("removeLinks", () => {
     expect(results[0].dryRun).toBe(true);
     expect(existsSync(dest)).toBe(true);
   });
+
+  test("dry run skips targets that are not symlinks", () => {
+    const dest = join(home, ".zshrc");
+    writeFileSync(dest, "real file");
+
+    const results = removeLinks("zsh", { "zshrc": [dest] }, tmp, { dryRun: true, verbose: false, interactive: false });
+    expect(results[0].skipped).toBe(true);
+    expect(results[0].dryRun).toBe(false);
+  });
+});
+
+describe("allLinksCorrect", () => {
+  let tmp: string;
+
+  beforeEach(() => {
+    tmp = makeTempDir();
+  });
+
+  afterEach(() => {
+    rmSync(tmp, { recursive: true, force: true });
+  });
+
+  test("checks links under the target prefix", () => {
+    const src = join(tmp, "zshrc");
+    writeFileSync(src, "# zsh config");
+    const dest = join(tmp, "home", ".zshrc");
+    const prefix = join(tmp, "rootfs");
+    mkdirSync(join(prefix, tmp, "home"), { recursive: true });
+    symlinkSync(src, join(prefix, dest));
+
+    expect(allLinksCorrect({ "zshrc": [dest] }, tmp)).toBe(false);
+    expect(allLinksCorrect({ "zshrc": [dest] }, tmp, prefix)).toBe(true);
+  });
+});
+
+describe("linkError", () => {
+  function permissionDenied(): NodeJS.ErrnoException {
+    return Object.assign(new Error("EACCES: permission denied, unlink '/etc/foo'"), { code: "EACCES" });
+  }
+
+  test.skipIf(process.getuid?.() === 0)("names a root-owned target", () => {
+    expect(linkError(permissionDenied(), "/etc/foo", () => 0)).toBe(
+      "target /etc/foo is owned by root; re-run with elevated permissions or remove it manually"
+    );
+  });
+
+  test.skipIf(process.getuid?.() === 0)("names a root-owned parent directory", () => {
+    const owner = (p: string) => (p === "/etc" ? 0 : null);
+    expect(linkError(permissionDenied(), "/etc/foo", owner)).toBe(
+      "directory /etc is owned by root, so /etc/foo can't be changed; re-run with elevated permissions or fix its ownership"
+    );
+  });
+
+  test("keeps the raw message for other owners and other errors", () => {
+    expect(linkError(permissionDenied(), "/etc/foo", () => 1000)).toContain("permission denied");
+    const missing = Object.assign(new Error("ENOENT: no such file"), { code: "ENOENT" });
+    expect(linkError(missing, "/etc/foo", () => 0)).toBe("ENOENT: no such file");
+  });
 });
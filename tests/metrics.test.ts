@@ -0,0 +1,94 @@
+import { describe, test, expect } from "bun:test";
+import { computeMetrics, formatDuration, outcomeStatus, ComponentOutcome } from "../src/metrics";
+
+function makeOutcome(overrides: Partial<ComponentOutcome> = {}): ComponentOutcome {
+  return {
+    name: "zsh",
+    installed: false,
+    linksCreated: 0,
+    linksCorrect: 0,
+    hooksRun: 0,
+    failed: false,
+    durationMs: 0,
+    ...overrides,
+  };
+}
+
+describe("outcomeStatus", () => {
+  test("failure wins over any work done", () => {
+    expect(outcomeStatus(makeOutcome({ installed: true, failed: true }))).toBe("failed");
+  });
+
+  test("a failure whose links were undone counts as rolled back", () => {
+    expect(outcomeStatus(makeOutcome({ failed: true, rolledBack: true }))).toBe("rolled back");
+  });
+
+  test("install wins over links", () => {
+    expect(outcomeStatus(makeOutcome({ installed: true, linksCreated: 2 }))).toBe("installed");
+  });
+
+  test("link-only changes count as linked", () => {
+    expect(outcomeStatus(makeOutcome({ linksCreated: 1 }))).toBe("linked");
+  });
+
+  test("no changes counts as skipped", () => {
+    expect(outcomeStatus(makeOutcome({ linksCorrect: 3 }))).toBe("skipped");
+  });
+});
+
+describe("computeMetrics", () => {
+  test("aggregates counts, links, time, and slowest", () => {
+    const outcomes = [
+      makeOutcome({ name: "zsh", installed: true, linksCreated: 1, durationMs: 120 }),
+      makeOutcome({ name: "nvim", installed: true, durationMs: 2100 }),
+      makeOutcome({ name: "git", linksCreated: 2, linksCorrect: 1, durationMs: 10 }),
+      makeOutcome({ name: "tmux", linksCorrect: 1, durationMs: 5 }),
+      makeOutcome({ name: "broken", failed: true, durationMs: 40 }),
+    ];
+
+    const metrics = computeMetrics(outcomes, 1000, 4500);
+    expect(metrics.total).toBe(5);
+    expect(metrics.installed).toBe(2);
+    expect(metrics.linked).toBe(1);
+    expect(metrics.skipped).toBe(1);
+    expect(metrics.failed).toBe(1);
+    expect(metrics.linksCreated).toBe(3);
+    expect(metrics.linksCorrect).toBe(2);
+    expect(metrics.durationMs).toBe(3500);
+    expect(metrics.slowest).toEqual({ name: "nvim", durationMs: 2100 });
+  });
+
+  test("rolled-back components also count as failed", () => {
+    const metrics = computeMetrics([
+      makeOutcome({ name: "broken", failed: true }),
+      makeOutcome({ name: "undone", failed: true, rolledBack: true }),
+    ], 0, 0);
+    expect(metrics.failed).toBe(2);
+    expect(metrics.rolledBack).toBe(1);
+  });
+
+  test("lists components that needed more than one attempt", () => {
+    const metrics = computeMetrics([
+      makeOutcome({ name: "zsh", installed: true, attempts: 1 }),
+      makeOutcome({ name: "nvim", installed: true, attempts: 3 }),
+      makeOutcome({ name: "git", linksCreated: 1 }),
+    ], 0, 0);
+    expect(metrics.retried).toEqual([{ name: "nvim", attempts: 3 }]);
+  });
+
+  test("empty run has no slowest component", () => {
+    const metrics = computeMetrics([], 0, 0);
+    expect(metrics.total).toBe(0);
+    expect(metrics.slowest).toBeNull();
+  });
+});
+
+describe("formatDuration", () => {
+  test("uses milliseconds under a second", () => {
+    expect(formatDuration(250)).toBe("250ms");
+  });
+
+  test("uses seconds with one decimal above a second", () => {
+    expect(formatDuration(2140)).toBe("2.1s");
+  });
+});
@@ -0,0 +1,181 @@
+import { describe, test, expect, beforeEach, afterEach } from "bun:test";
+import { repoCacheDir, syncRepo, changedFiles, changedSections, componentsForPaths, requireGit } from "../src/repo";
+import type { Component } from "../src/config";
+import { tmpdir } from "node:os";
+import { mkdtempSync, writeFileSync, rmSync, existsSync, readFileSync } from "node:fs";
+import { join } from "node:path";
+
+function makeTempDir(): string {
+  return mkdtempSync(join(tmpdir(), "dot-repo-test-"));
+}
+
+function git(cwd: string, ...args: string[]): void {
+  const proc = Bun.spawnSync(["git", "-c", "user.name=dot", "-c", "user.email=dot@example.com", ...args], { cwd });
+  if (proc.exitCode !== 0) throw new Error(proc.stderr.toString());
+}
+
+describe("syncRepo", () => {
+  let origin: string;
+  let cache: string;
+  const originalCache = process.env.XDG_CACHE_HOME;
+
+  beforeEach(() => {
+    origin = makeTempDir();
+    cache = makeTempDir();
+    process.env.XDG_CACHE_HOME = cache;
+    git(origin, "init", "--quiet", "--initial-branch=main");
+    writeFileSync(join(origin, "dot.toml"), `[zsh]\ninstall.any = "echo one"\n`);
+    git(origin, "add", "dot.toml");
+    git(origin, "commit", "--quiet", "-m", "one");
+  });
+
+  afterEach(() => {
+    rmSync(origin, { recursive: true, force: true });
+    rmSync(cache, { recursive: true, force: true });
+    if (originalCache === undefined) delete process.env.XDG_CACHE_HOME;
+    else process.env.XDG_CACHE_HOME = originalCache;
+  });
+
+  test("clones into the cache dir", () => {
+    const dir = syncRepo(origin, { ref: null, dryRun: false, verbose: false });
+    expect(dir).toBe(repoCacheDir(origin));
+    expect(dir.startsWith(cache)).toBe(true);
+    expect(existsSync(join(dir, "dot.toml"))).toBe(true);
+  });
+
+  test("pulls when already cloned", () => {
+    const dir = syncRepo(origin, { ref: null, dryRun: false, verbose: false });
+    writeFileSync(join(origin, "dot.toml"), `[zsh]\ninstall.any = "echo two"\n`);
+    git(origin, "commit", "--quiet", "-am", "two");
+
+    syncRepo(origin, { ref: null, dryRun: false, verbose: false });
+    expect(readFileSync(join(dir, "dot.toml"), "utf8")).toContain("echo two");
+  });
+
+  test("checks out the requested ref", () => {
+    git(origin, "tag", "v1");
+    writeFileSync(join(origin, "dot.toml"), `[zsh]\ninstall.any = "echo two"\n`);
+    git(origin, "commit", "--quiet", "-am", "two");
+
+    const dir = syncRepo(origin, { ref: "v1", dryRun: false, verbose: false });
+    expect(readFileSync(join(dir, "dot.toml"), "utf8")).toContain("echo one");
+  });
+
+  test("goes back to the default branch once no ref is given", () => {
+    git(origin, "tag", "v1");
+    git(origin, "branch", "other");
+    writeFileSync(join(origin, "dot.toml"), `[zsh]\ninstall.any = "echo two"\n`);
+    git(origin, "commit", "--quiet", "-am", "two");
+
+    const dir = syncRepo(origin, { ref: "v1", dryRun: false, verbose: false });
+    syncRepo(origin, { ref: null, dryRun: false, verbose: false });
+    expect(readFileSync(join(dir, "dot.toml"), "utf8")).toContain("echo two");
+
+    syncRepo(origin, { ref: "other", dryRun: false, verbose: false });
+    expect(readFileSync(join(dir, "dot.toml"), "utf8")).toContain("echo one");
+    syncRepo(origin, { ref: null, dryRun: false, verbose: false });
+    expect(readFileSync(join(dir, "dot.toml"), "utf8")).toContain("echo two");
+  });
+
+  test("URLs that slug the same still get their own cache dirs", () => {
+    expect(repoCacheDir("https://host/a/b")).not.toBe(repoCacheDir("https://host/a-b"));
+    expect(repoCacheDir("https://host/a/b")).toBe(repoCacheDir("https://host/a/b"));
+  });
+
+  test("dry run refuses to clone", () => {
+    expect(() => syncRepo(origin, { ref: null, dryRun: true, verbose: false })).toThrow("not been cloned");
+    expect(existsSync(repoCacheDir(origin))).toBe(false);
+  });
+});
+
+describe("components from a git diff", () => {
+  function component(name: string, link: Record<string, string[]>, defaults: Record<string, string> = {}): Component {
+    return { name, install: {}, uninstall: {}, link, defaults, defaultsSet: {} };
+  }
+
+  const components = [
+    component("nvim", { "nvim/": ["~/.config/nvim"] }),
+    component("git", { "git/gitconfig": ["~/.gitconfig"] }),
+    component("finder", {}, { "com.apple.finder": "macos/finder.plist" }),
+  ];
+
+  test("maps changed files to the components that link them", () => {
+    expect(componentsForPaths(components, ["nvim/lua/plugins.lua", "README.md"])).toEqual(["nvim"]);
+    expect(componentsForPaths(components, ["git/gitconfig", "macos/finder.plist"])).toEqual(["git", "finder"]);
+    expect(componentsForPaths(components, ["nvim-old/init.lua", "git/gitconfig.bak"])).toEqual([]);
+  });
+
+  test("lists files changed since a ref, relative to the config dir", () => {
+    const repo = makeTempDir();
+    try {
+      git(repo, "init", "--quiet", "--initial-branch=main");
+      writeFileSync(join(repo, "gitconfig"), "[user]\n");
+      writeFileSync(join(repo, "zshrc"), "# zsh\n");
+      git(repo, "add", ".");
+      git(repo, "commit", "--quiet", "-m", "one");
+      writeFileSync(join(repo, "zshrc"), "# zsh, edited\n");
+
+      expect(changedFiles("HEAD", repo)).toEqual(["zshrc"]);
+      expect(() => changedFiles("no-such-ref", repo)).toThrow("git diff failed");
+
+      writeFileSync(join(repo, "vimrc"), "\" vim\n");
+      writeFileSync(join(repo, ".gitignore"), "*.log\n");
+      writeFileSync(join(repo, "debug.log"), "noise\n");
+      expect(changedFiles("HEAD", repo).sort()).toEqual([".gitignore", "vimrc", "zshrc"]);
+    } finally {
+      rmSync(repo, { recursive: true, force: true });
+    }
+  });
+
+  test("names the dot.toml sections and settings that changed since a ref", () => {
+    const repo = makeTempDir();
+    try {
+      git(repo, "init", "--quiet", "--initial-branch=main");
+      writeFileSync(join(repo, "dot.toml"), `defaults_format = "xml"\n\n[zsh]\nlink."zshrc" = "~/.zshrc"\n\n[vim]\nlink."vimrc" = "~/.vimrc"\n\n[tmux]\ninstall.any = "true"\n`);
+      git(repo, "add", ".");
+      git(repo, "commit", "--quiet", "-m", "one");
+      writeFileSync(join(repo, "dot.toml"), `disabled_managers = ["snap"]\n\n[zsh]\nlink."zshrc" = "~/.zshrc"\n\n[vim]\nlink."vimrc" = "~/.config/vim/vimrc"\n\n[git]\nlink."gitconfig" = "~/.gitconfig"\n`);
+
+      expect(changedSections("HEAD", "dot.toml", repo)).toEqual({
+        sections: ["vim", "git"],
+        settings: ["defaults_format", "disabled_managers"],
+      });
+    } finally {
+      rmSync(repo, { recursive: true, force: true });
+    }
+  });
+});
+
+describe("requireGit", () => {
+  let repo: string;
+
+  beforeEach(() => {
+    repo = makeTempDir();
+  });
+
+  afterEach(() => {
+    rmSync(repo, { recursive: true, force: true });
+  });
+
+  test("refuses a config outside a git repo", () => {
+    writeFileSync(join(repo, "dot.toml"), "");
+    expect(requireGit(join(repo, "dot.toml"), false)).toBe(`${repo} is not inside a git repository (--require-git)`);
+    expect(requireGit(join(repo, "dot.toml"), true)).toContain("not inside a git repository");
+  });
+
+  test("accepts a committed config and refuses an edited or untracked one", () => {
+    git(repo, "init", "--quiet", "--initial-branch=main");
+    writeFileSync(join(repo, "dot.toml"), `[zsh]\ninstall.any = "true"\n`);
+    expect(requireGit(join(repo, "dot.toml"), false)).toContain("dot.toml has uncommitted changes");
+
+    git(repo, "add", "dot.toml");
+    git(repo, "commit", "--quiet", "-m", "one");
+    expect(requireGit(join(repo, "dot.toml"), false)).toBeNull();
+
+    writeFileSync(join(repo, "dot.toml"), `[zsh]\ninstall.any = "false"\n`);
+    expect(requireGit(join(repo, "dot.toml"), false)).toBe(
+      "dot.toml has uncommitted changes; commit them or pass --allow-dirty"
+    );
+    expect(requireGit(join(repo, "dot.toml"), true)).toBeNull();
+  });
+});
@@ -0,0 +1,92 @@
+import { describe, test, expect, beforeEach, afterEach } from "bun:test";
+import { renderMarkdown, renderHtml, writeReport, ReportInfo } from "../src/report";
+import { computeMetrics, ComponentOutcome } from "../src/metrics";
+import { tmpdir } from "node:os";
+import { mkdtempSync, rmSync, readFileSync } from "node:fs";
+import { join } from "node:path";
+
+function makeOutcome(overrides: Partial<ComponentOutcome> = {}): ComponentOutcome {
+  return {
+    name: "zsh",
+    installed: false,
+    linksCreated: 0,
+    linksCorrect: 0,
+    hooksRun: 0,
+    failed: false,
+    durationMs: 0,
+    ...overrides,
+  };
+}
+
+const outcomes = [
+  makeOutcome({ name: "zsh", installed: true, command: "brew install zsh", attempts: 1, linksCreated: 1, durationMs: 1200 }),
+  makeOutcome({ name: "nvim", failed: true, command: "brew install neovim", attempts: 3, durationMs: 40 }),
+];
+
+const info: ReportInfo = {
+  os: "mac",
+  date: new Date("2026-01-02T03:04:05Z"),
+  metrics: computeMetrics(outcomes, 0, 1500),
+  failures: ["nvim"],
+};
+
+describe("renderMarkdown", () => {
+  test("includes a header and one row per component", () => {
+    const md = renderMarkdown(outcomes, info);
+    expect(md).toContain("# dot run report");
+    expect(md).toContain("- Date: 2026-01-02T03:04:05.000Z");
+    expect(md).toContain(`- OS: mac (${process.arch})`);
+    expect(md).toContain("| zsh | installed | `brew install zsh` | 1 | 1 | 0 | 0 | 1.2s |");
+    expect(md).toContain("| nvim | failed | `brew install neovim` | 3 | 0 | 0 | 0 | 40ms |");
+    expect(md).toContain("## Failures\n\n- nvim");
+  });
+
+  test("marks rolled-back components in the rows and the summary", () => {
+    const rolled = [makeOutcome({ name: "nvim", failed: true, rolledBack: true, durationMs: 40 })];
+    const md = renderMarkdown(rolled, { ...info, metrics: computeMetrics(rolled, 0, 40) });
+    expect(md).toContain("| nvim | rolled back |");
+    expect(md).toContain("1 failed, 1 of them rolled back");
+  });
+
+  test("puts hook output in a fenced block per component", () => {
+    const withHook = [makeOutcome({ name: "zsh", hookOutput: [{ hook: "postlink", output: "reloaded\n" }] })];
+    const md = renderMarkdown(withHook, { ...info, failures: [] });
+    expect(md).toContain("## Hook output\n\n### zsh postlink\n\n```\nreloaded\n```\n");
+  });
+});
+
+describe("renderHtml", () => {
+  test("renders rows as table cells and escapes values", () => {
+    const html = renderHtml([makeOutcome({ name: "a<b", command: "echo \"x\" > y" })], { ...info, failures: [] });
+    expect(html).toContain("<td>a&lt;b</td>");
+    expect(html).toContain("<td>echo &quot;x&quot; &gt; y</td>");
+    expect(html).not.toContain("Failures");
+  });
+
+  test("shows hook output in a preformatted block", () => {
+    const html = renderHtml(
+      [makeOutcome({ name: "zsh", hookOutput: [{ hook: "postinstall", output: "plugins <ok>\n" }] })],
+      { ...info, failures: [] }
+    );
+    expect(html).toContain("<h3>zsh postinstall</h3>\n<pre>plugins &lt;ok&gt;</pre>");
+  });
+});
+
+describe("writeReport", () => {
+  let tmp: string;
+
+  beforeEach(() => {
+    tmp = mkdtempSync(join(tmpdir(), "dot-report-test-"));
+  });
+
+  afterEach(() => {
+    rmSync(tmp, { recursive: true, force: true });
+  });
+
+  test("picks the format from the extension", () => {
+    writeReport(join(tmp, "run.md"), outcomes, info);
+    writeReport(join(tmp, "run.html"), outcomes, info);
+    expect(readFileSync(join(tmp, "run.md"), "utf8")).toStartWith("# dot run report");
+    expect(readFileSync(join(tmp, "run.html"), "utf8")).toStartWith("<!doctype html>");
+  });
+});
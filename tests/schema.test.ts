@@ -0,0 +1,41 @@
+import { describe, test, expect } from "bun:test";
+import { configSchema, componentSchema } from "../src/schema";
+import { COMPONENT_KEYS } from "../src/config";
+import { parseArgs } from "../src/cli";
+import { join } from "node:path";
+
+describe("configSchema", () => {
+  test("describes the common component keys", () => {
+    const props = componentSchema.properties as Record<string, unknown>;
+    for (const key of ["install", "uninstall", "link", "postinstall", "postlink", "os", "check"]) {
+      expect(props[key]).toBeDefined();
+    }
+  });
+
+  test("stays in sync with the keys the parser accepts", () => {
+    expect(Object.keys(componentSchema.properties).sort()).toEqual([...COMPONENT_KEYS].sort());
+  });
+
+  test("components are any other top-level table", () => {
+    const schema = configSchema();
+    expect(schema.additionalProperties).toBe(componentSchema);
+    expect(Object.keys(schema.properties as object)).toContain("postrun");
+  });
+});
+
+describe("--schema", () => {
+  test("parses as a meta command", () => {
+    expect(parseArgs(["dot", "--schema"]).meta).toBe("schema");
+    expect(parseArgs(["dot", "schema"]).meta).toBe("schema");
+  });
+
+  test("prints valid JSON", async () => {
+    const child = Bun.spawn([process.execPath, join(import.meta.dir, "../src/index.ts"), "--schema"], {
+      stdout: "pipe",
+      stderr: "pipe",
+    });
+    const schema = JSON.parse(await new Response(child.stdout).text());
+    expect(await child.exited).toBe(0);
+    expect(schema.additionalProperties.properties.link).toBeDefined();
+  });
+});
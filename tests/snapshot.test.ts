@@ -0,0 +1,21 @@
+import { describe, test, expect } from "bun:test";
+import { formatSnapshot, parseSnapshot } from "../src/snapshot";
+
+describe("snapshot files", () => {
+  test("YAML and JSON both round-trip", () => {
+    const names = ["zsh", "git", "nvim"];
+    expect(parseSnapshot(formatSnapshot(names, "components.yaml"), "components.yaml")).toEqual(names);
+    expect(parseSnapshot(formatSnapshot(names, "components.json"), "components.json")).toEqual(names);
+  });
+
+  test("accepts quoted items and comments", () => {
+    const text = `# my laptop\ncomponents:\n  - "zsh"  # shell\n  - 'git'\n`;
+    expect(parseSnapshot(text, "laptop.yml")).toEqual(["zsh", "git"]);
+  });
+
+  test("rejects files without a components list", () => {
+    expect(() => parseSnapshot("zsh: true\n", "components.yaml")).toThrow('expected a "components:" list');
+    expect(() => parseSnapshot("", "components.yaml")).toThrow('missing "components:" list');
+    expect(() => parseSnapshot(`{"names": []}`, "components.json")).toThrow('expected a "components" list');
+  });
+});
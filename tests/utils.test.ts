@@ -1,5 +1,8 @@
 import { describe, test, expect } from "bun:test";
-import { detectOS, expandPath, binaryExists, isTTY } from "../src/utils";
+import { detectOS, expandPath, binaryExists, isTTY, targetPath, commandText, samePath, expandEnv } from "../src/utils";
+import { homedir, userInfo, tmpdir } from "node:os";
+import { mkdtempSync, writeFileSync, existsSync, rmSync } from "node:fs";
+import { join } from "node:path";
 
 describe("detectOS", () => {
   test("returns current platform", () => {
@@ -56,6 +59,121 @@ describe("expandPath", () => {
     expect(expandPath("~/file")).toBe("~/file");
     process.env.HOME = originalHome;
   });
+
+  test("expands ~user for the current user", () => {
+    process.env.HOME = originalHome;
+    const { username } = userInfo();
+    expect(expandPath(`~${username}/.zshrc`)).toBe(`${homedir()}/.zshrc`);
+    expect(expandPath(`~${username}`)).toBe(homedir());
+  });
+
+  test("throws for an unknown user", () => {
+    expect(() => expandPath("~dot-no-such-user/.zshrc")).toThrow("unknown user");
+  });
+
+  test("finds other users through the system user database", () => {
+    if (process.platform === "win32") return;
+    expect(expandPath("~root/.profile")).toMatch(/\/\.profile$/);
+    expect(expandPath("~root/.profile")).not.toStartWith("~");
+  });
+
+  describe("on Windows", () => {
+    const env = {
+      USERPROFILE: "C:\\Users\\me",
+      APPDATA: "C:\\Users\\me\\AppData\\Roaming",
+    };
+    const cases: [string, string][] = [
+      ["%APPDATA%\\Code\\User\\settings.json", "C:\\Users\\me\\AppData\\Roaming\\Code\\User\\settings.json"],
+      ["%appdata%/alacritty/alacritty.toml", "C:\\Users\\me\\AppData\\Roaming\\alacritty\\alacritty.toml"],
+      ["~/.gitconfig", "C:\\Users\\me\\.gitconfig"],
+      ["~\\.gitconfig", "C:\\Users\\me\\.gitconfig"],
+      ["C:/tools/bin", "C:\\tools\\bin"],
+      ["%NOT_SET%\\x", "%NOT_SET%\\x"],
+    ];
+
+    for (const [input, expected] of cases) {
+      test(`${input} → ${expected}`, () => {
+        expect(expandPath(input, "win32", env)).toBe(expected);
+      });
+    }
+  });
+});
+
+describe("targetPath", () => {
+  test("expands ~ without a prefix", () => {
+    process.env.HOME = "/home/user";
+    expect(targetPath("~/.zshrc")).toBe("/home/user/.zshrc");
+  });
+
+  test("prepends the prefix after expansion", () => {
+    process.env.HOME = "/home/user";
+    expect(targetPath("~/.zshrc", "/rootfs")).toBe("/rootfs/home/user/.zshrc");
+    expect(targetPath("/etc/hosts", "/rootfs")).toBe("/rootfs/etc/hosts");
+  });
+
+  test("drops a trailing slash", () => {
+    process.env.HOME = "/home/user";
+    expect(targetPath("~/.config/nvim/")).toBe("/home/user/.config/nvim");
+    expect(targetPath("/")).toBe("/");
+    expect(targetPath("C:\\Users\\me\\")).toBe("C:\\Users\\me");
+  });
+
+  test("keeps the slash of a bare drive root", () => {
+    expect(targetPath("C:\\")).toBe("C:\\");
+    expect(targetPath("D:/")).toBe("D:/");
+  });
+});
+
+describe("commandText", () => {
+  test("returns shell strings unchanged", () => {
+    expect(commandText("brew install zsh && echo ok")).toBe("brew install zsh && echo ok");
+  });
+
+  test("joins argument lists and quotes special arguments", () => {
+    expect(commandText(["touch", "a b", "$HOME"])).toBe('touch "a b" "$HOME"');
+  });
+});
+
+describe("expandEnv", () => {
+  const env = { HOME: "/home/me", TOOL: "nvim" };
+
+  test("expands $VAR and ${VAR}", () => {
+    expect(expandEnv("echo $HOME/bin ${TOOL}-x", env)).toBe("echo /home/me/bin nvim-x");
+    expect(expandEnv('echo "$HOME"', env)).toBe('echo "/home/me"');
+  });
+
+  test("leaves command substitution literal", () => {
+    expect(expandEnv("echo $HOME $(date) `whoami`", env)).toBe("echo /home/me $(date) `whoami`");
+    expect(expandEnv("echo $(echo $HOME)", env)).toBe("echo $(echo $HOME)");
+    expect(expandEnv("echo $((1 + 2))", env)).toBe("echo $((1 + 2))");
+  });
+
+  test("leaves single quotes and escapes alone", () => {
+    expect(expandEnv("echo '$HOME' \\$HOME", env)).toBe("echo '$HOME' \\$HOME");
+  });
+
+  test("unset variables expand to nothing, like the shell", () => {
+    expect(expandEnv("echo [$NOPE]", env)).toBe("echo []");
+  });
+});
+
+describe("samePath", () => {
+  test("identical paths are equal and different names are not", () => {
+    expect(samePath("/home/me/.config", "/home/me/.config")).toBe(true);
+    expect(samePath("/home/me/.config", "/home/me/.cache")).toBe(false);
+  });
+
+  test("case-differing paths are equal only where the filesystem says they are one file", () => {
+    const dir = mkdtempSync(join(tmpdir(), "dot-case-"));
+    try {
+      writeFileSync(join(dir, "Config"), "");
+      const insensitive = existsSync(join(dir, "config"));
+      expect(samePath(join(dir, "Config"), join(dir, "config"))).toBe(insensitive);
+      expect(samePath(join(dir, "Missing"), join(dir, "missing"))).toBe(false);
+    } finally {
+      rmSync(dir, { recursive: true, force: true });
+    }
+  });
 });
 
 describe("binaryExists", () => {